@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// SetupAutoConfigAuthorizer configures this server to validate intro tokens minted for the cluster.
+func SetupAutoConfigAuthorizer(zeroConfClient *consul.ConsulClient) {
+	key := bootstrap.FetchAutoConfigKey(zeroConfClient, *clusterId, *encryptionKey)
+	bootstrap.SaveAutoConfigAuthorizer(key, *clusterId, *consulConfigDir, "auto_config")
+}
+
+// SetupAutoConfigClient mints an intro token for this node and writes its client auto_config stanza.
+func SetupAutoConfigClient(zeroConfClient *consul.ConsulClient) {
+	key := bootstrap.FetchAutoConfigKey(zeroConfClient, *clusterId, *encryptionKey)
+	introToken := bootstrap.MintIntroToken(key, *clusterId, *consulNodeName, *autoConfigTtl)
+	bootstrap.SaveAutoConfigClient(introToken, AutoConfigServers(), *consulConfigDir, "auto_config")
+}
+
+func AutoConfigServers() []string {
+	var servers []string
+	for _, server := range strings.Split(*autoConfigServers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+
+	return servers
+}
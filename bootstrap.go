@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"time"
 
 	consulApi "github.com/hashicorp/consul/api"
 	"redserenity.com/consul-bootstrap/bootstrap"
@@ -11,6 +12,9 @@ import (
 func BootstrapCommon(config *consulApi.Config, retries, delay int) (*consul.ConsulClient, *consulApi.ACLToken) {
 	consulClient := ConnectConsulServer(config, retries, delay)
 
+	// ACL bootstrap fails without a leader and while the ACL system is still in legacy mode.
+	WaitForReady(retries, delay, bootstrap.LeaderCondition(consulClient), bootstrap.AclCondition(consulClient))
+
 	var bootstrapAclToken *consulApi.ACLToken
 
 	// If a token is provided, then we will skip bootstrapping and continue on...
@@ -30,57 +34,226 @@ func BootstrapCommon(config *consulApi.Config, retries, delay int) (*consul.Cons
 
 func BootstrapServer(client *consul.ConsulClient, bootstrapAclToken *consulApi.ACLToken) {
 	if bootstrapAclToken != nil {
-		bootstrap.SaveBootstrapKey(client, "self", bootstrapAclToken)
+		bootstrap.SaveClusterBootstrapToken(client, bootstrap.SERVER_CLUSTER_ID, bootstrapAclToken, *encryptionKey)
 	}
 
 	bootstrap.SetupAnonPolicies(client)
 
 	nodeToken := bootstrap.SetupNodePolicy(client, *consulNodeName, *consulNodePrefix)
-	bootstrap.UpdateAclConfig(nodeToken, *consulConfigDir, "acl.hcl")
+	bootstrap.UpdateAclConfig(nodeToken, *consulConfigDir, "acl")
 
-	regToken := bootstrap.SetupRegisterToken(client)
-	bootstrap.SaveRegisterToken(regToken, "", *zeroConfDir, "zeroconf.json")
+	regToken := bootstrap.SetupRegisterToken(client, *clusterId)
 	log.Printf("==> (Sensitive) Service Registration Token = %s", regToken.SecretID)
 
-	bootstrap.SetupClusterKV(client)
-	bootstrap.LockDownNodeJoining(*consulConfigDir, "gossip.hcl")
-	log.Printf("==> Bootstrapping complete! A restart may be required for all ACL configurations to work.")
+	clusterGossipKey := *gossipKey
+	if clusterGossipKey == "" {
+		clusterGossipKey = bootstrap.GenerateKey()
+	}
+	bootstrap.SaveGossipKey(client, AgentClusterId(), clusterGossipKey, *encryptionKey)
+	bootstrap.LockDownNodeJoining(clusterGossipKey, *consulConfigDir, "gossip")
+	bootstrap.VerifyGossipKey(client, clusterGossipKey)
+	ApplyOperatorSettings(client)
+
+	if profile := LoadAgentProfile(); profile != nil {
+		bootstrap.SaveProfile(profile, *serverCount, *consulConfigDir)
+	}
+
+	if *enableTls {
+		SetupAgentTls(client, client, true)
+	}
+
+	// Written once TLS is set up, so the profile can pin the CA.
+	bootstrap.SaveZeroConfProfile(ServerZeroConfProfile(client, regToken.SecretID), *zeroConfDir, "zeroconf.json")
+
+	ApplyAgentChanges(client)
+	log.Printf("==> Bootstrapping complete!")
+}
+
+// BootstrapCluster bootstraps one node of a cluster. The one-time cluster steps run under a lock on the
+// ZeroConf server, so when several nodes start at once only the first performs them and the others reuse its results.
+func BootstrapCluster(config *consulApi.Config, retries, delay int) {
+	zeroConfConsul := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
+
+	SetupNodeRole(zeroConfConsul)
+
+	lock := bootstrap.LockClusterBootstrap(zeroConfConsul, *clusterId, *consulNodeName, time.Duration(retries*delay)*time.Second)
+	client := BootstrapClusterOnce(zeroConfConsul, config, retries, delay)
+	bootstrap.UnlockClusterBootstrap(lock, *clusterId)
+
+	ApplyFederation(zeroConfConsul)
+
+	clusterGossipKey := bootstrap.FetchGossipKey(zeroConfConsul, *clusterId, *encryptionKey)
+	bootstrap.LockDownNodeJoining(clusterGossipKey, *consulConfigDir, "gossip")
+	bootstrap.VerifyGossipKey(client, clusterGossipKey)
+	ApplyOperatorSettings(client)
+
+	if *enableTls {
+		SetupAgentTls(client, client, true)
+	}
+
+	if *autoConfig {
+		SetupAutoConfigAuthorizer(client)
+	}
+
+	ApplyAgentChanges(client)
+	log.Printf("==> Bootstrapping complete!")
 }
 
-func BootstrapCluster(client *consul.ConsulClient, bootstrapAclToken *consulApi.ACLToken, retries, delay int) {
+// BootstrapClusterOnce bootstraps the cluster ACLs unless another node already did, and returns a client using the cluster bootstrap token.
+// Must be called while holding the cluster bootstrap lock.
+func BootstrapClusterOnce(zeroConfConsul *consul.ConsulClient, config *consulApi.Config, retries, delay int) *consul.ConsulClient {
+	if *bootstrapToken == "" {
+		if token := bootstrap.GetClusterBootstrapToken(zeroConfConsul, *clusterId, *encryptionKey); token != "" {
+			log.Printf("==> Cluster %s was already bootstrapped by another node. Using its bootstrap token.", *clusterId)
+
+			client := ConnectConsulServer(config, retries, delay)
+			client.Token = token
+			WaitForLeader(client, retries, delay)
+			return client
+		}
+
+		if bootstrap.IsClusterBootstrapped(zeroConfConsul, *clusterId) {
+			log.Fatalf("==> Cluster %s was already bootstrapped by another node, but its bootstrap token is only stored with -encryption-key. Add -bootstrap-token to continue.", *clusterId)
+		}
+	}
+
+	client, bootstrapAclToken := BootstrapCommon(config, retries, delay)
+	if bootstrapAclToken == nil && *bootstrapToken == "" {
+		log.Fatalf("==> Unable to bootstrap cluster %s. Add -bootstrap-token to continue with an existing token.", *clusterId)
+	}
+
 	if bootstrapAclToken != nil {
-		zeroConfConsul := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
-		bootstrap.SaveBootstrapKey(zeroConfConsul, "cluster", bootstrapAclToken)
+		bootstrap.SaveClusterBootstrapToken(zeroConfConsul, *clusterId, bootstrapAclToken, *encryptionKey)
 	}
 
 	bootstrap.SetupAnonPolicies(client)
-	log.Printf("==> Bootstrapping complete! A restart may be required for all ACL configurations to work.")
+
+	return client
 }
 
 func RegisterZeroConfNode(config *consulApi.Config, retries, delay int) {
 	if *zeroConfAddress == "" || *zeroConfToken == "" {
-		log.Fatal("-zeroconf-address and -zeroconf-token are required.")
+		log.Fatal("-zeroconf-address and -zeroconf-token (or -zeroconf-ticket or -zeroconf-file) are required.")
 	}
 
-	consulClient := ConnectConsulServer(config, retries, delay)
 	zeroConfClient := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
+	CheckNodeClaim(zeroConfClient)
+
+	// With auto_config the agent receives its ACL token, gossip key and certificates from the cluster servers.
+	if *autoConfig && *nodeRole == bootstrap.ROLE_CLIENT {
+		SetupNodeRole(zeroConfClient)
+		SetupAutoConfigClient(zeroConfClient)
+		SaveNodeInventory(zeroConfClient, RegisterZeroConfService(zeroConfClient, nil, retries, delay), "")
+		RenderJoinConfig(zeroConfClient)
+		return
+	}
 
-	if !consul.PolicyExistsByName(consulClient, *consulNodePrefix+*consulNodeName) {
+	consulClient := ConnectConsulServer(config, retries, delay)
+
+	if err := bootstrap.MigrateNodePolicy(consulClient, *consulNodePrefix, *consulNodeName); err != nil {
+		log.Fatal(err)
+	}
+
+	tokenAccessor := ""
+	if !consul.PolicyExistsByName(consulClient, bootstrap.NodePolicyName(*consulNodePrefix, *consulNodeName)) {
 		log.Printf("==> Registering Node (%s) with ZeroConf Server...", *consulNodeName)
 		nodeToken := bootstrap.SetupNodePolicy(consulClient, *consulNodeName, *consulNodePrefix)
-		err := consul.SaveKV(zeroConfClient, "cluster/nodes/"+*consulNodeName+"/token", nodeToken.SecretID)
+		bootstrap.SaveNodeToken(zeroConfClient, *clusterId, *consulNodeName, nodeToken.SecretID, *encryptionKey)
+		tokenAccessor = nodeToken.AccessorID
+		log.Printf("==> Registered node %s with ZeroConf Server", *consulNodeName)
+	}
+
+	SetupNodeRole(zeroConfClient)
+	ApplyFederation(zeroConfClient)
+	SaveNodeInventory(zeroConfClient, RegisterZeroConfService(zeroConfClient, consulClient, retries, delay), tokenAccessor)
+	RenderJoinConfig(zeroConfClient)
+
+	clusterGossipKey := SyncGossipKey(zeroConfClient)
+	bootstrap.VerifyGossipKey(consulClient, clusterGossipKey)
+
+	if *enableTls {
+		SetupAgentTls(zeroConfClient, consulClient, *nodeRole == bootstrap.ROLE_SERVER)
+	}
+
+	if *autoConfig {
+		SetupAutoConfigAuthorizer(zeroConfClient)
+	}
+
+	ApplyAgentChanges(consulClient)
+}
+
+// CheckNodeClaim stops registration when the node name belongs to a different host, unless -force is given.
+func CheckNodeClaim(zeroConfClient *consul.ConsulClient) {
+	err := bootstrap.CheckNodeClaim(zeroConfClient, *clusterId, *consulNodeName, bootstrap.LocalMachineId(), ResolveAdvertiseAddress())
+	if err == nil {
+		return
+	}
+
+	if !*force {
+		log.Fatalf("==> %s. Use -force to take over the name.", err)
+	}
+
+	log.Printf("==> %s. Taking over the name (-force).", err)
+}
+
+// SetupNodeRole claims a server slot on the ZeroConf server for servers and writes the role specific agent config,
+// or every file of -profile when one is selected.
+func SetupNodeRole(zeroConfClient *consul.ConsulClient) {
+	bootstrapExpect := 0
+	if *nodeRole == bootstrap.ROLE_SERVER {
+		expect, err := bootstrap.ClaimServerSlot(zeroConfClient, *clusterId, *consulNodeName, *serverCount)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("==> %s.", err)
 		}
-		log.Printf("==> Registered node %s with ZeroConf Server", *consulNodeName)
+		bootstrapExpect = expect
 	}
 
-	service := &consulApi.AgentServiceRegistration{
-		ID:   bootstrap.SanitizeNodeName(*consulNodeName),
-		Name: "consul-cluster",
-		Port: 8500,
+	if profile := LoadAgentProfile(); profile != nil {
+		bootstrap.SaveProfile(profile, bootstrapExpect, *consulConfigDir)
+		return
 	}
 
+	bootstrap.SaveRoleConfig(*nodeRole, bootstrapExpect, *consulConfigDir)
+}
+
+// RegisterZeroConfService registers this node as a consul-cluster instance. localClient may be nil when
+// the local agent is not reachable yet, in which case its version and datacenter are left out.
+func RegisterZeroConfService(zeroConfClient, localClient *consul.ConsulClient, retries, delay int) *bootstrap.ClusterService {
+	address := ResolveAdvertiseAddress()
+
+	clusterService := &bootstrap.ClusterService{
+		NodeName:   *consulNodeName,
+		ClusterId:  *clusterId,
+		Datacenter: *datacenter,
+		Role:       *nodeRole,
+		Address:    address,
+		HttpPort:   *httpPort,
+		RpcPort:    *rpcPort,
+		SerfPort:   *serfPort,
+	}
+
+	if *enableTls {
+		clusterService.HttpsPort = bootstrap.AGENT_HTTPS_PORT
+	}
+
+	if localClient != nil {
+		if clusterService.Datacenter == "" {
+			if agentDatacenter, err := consul.GetAgentDatacenter(localClient); err == nil {
+				clusterService.Datacenter = agentDatacenter
+			} else {
+				log.Printf("==> Unable to detect the agent datacenter (%s).", err)
+			}
+		}
+
+		if version, err := consul.GetAgentVersion(localClient); err == nil {
+			clusterService.ConsulVersion = version
+		} else {
+			log.Printf("==> Unable to detect the Consul version (%s).", err)
+		}
+	}
+
+	service := bootstrap.NewClusterServiceRegistration(clusterService, *heartbeatTtl)
+
 	agentClient := zeroConfClient.Client.Agent()
 
 	if err := agentClient.ServiceRegister(service); err != nil {
@@ -88,6 +261,42 @@ func RegisterZeroConfNode(config *consulApi.Config, retries, delay int) {
 	}
 
 	log.Printf("==> Registered service %s with ZeroConf Server", bootstrap.SanitizeNodeName(*consulNodeName))
+
+	if legacyId := bootstrap.LegacyServiceId(zeroConfClient, *consulNodeName, address); legacyId != "" {
+		log.Printf("==> Removing the registration of %s under its legacy service ID %s.", *consulNodeName, legacyId)
+
+		if err := agentClient.ServiceDeregister(legacyId); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Later steps read the catalog, so wait until the registration has been synced to it.
+	WaitForReady(retries, delay, bootstrap.NodeRegisteredCondition(zeroConfClient, *consulNodeName))
+
+	return clusterService
+}
+
+// ResolveAdvertiseAddress returns -advertise-address, detecting and remembering it when omitted.
+func ResolveAdvertiseAddress() string {
+	if *advertiseAddress == "" {
+		*advertiseAddress = bootstrap.DetectAdvertiseAddress(*zeroConfAddress)
+		log.Printf("==> Detected advertise address %s", *advertiseAddress)
+	}
+
+	return *advertiseAddress
+}
+
+func SaveNodeInventory(zeroConfClient *consul.ConsulClient, service *bootstrap.ClusterService, tokenAccessor string) {
+	bootstrap.SaveNodeRecord(zeroConfClient, &bootstrap.ClusterNode{
+		Name:          service.NodeName,
+		Address:       service.Address,
+		MachineId:     bootstrap.LocalMachineId(),
+		Role:          service.Role,
+		ClusterId:     service.ClusterId,
+		Datacenter:    service.Datacenter,
+		ConsulVersion: service.ConsulVersion,
+		TokenAccessor: tokenAccessor,
+	})
 }
 
 func DeregisterZeroConfNode(config *consulApi.Config, retries, delay int) {
@@ -98,11 +307,90 @@ func DeregisterZeroConfNode(config *consulApi.Config, retries, delay int) {
 	zeroConfClient := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
 	agentClient := zeroConfClient.Client.Agent()
 
-	if err := agentClient.ServiceDeregister(bootstrap.SanitizeNodeName(*consulNodeName)); err != nil {
+	address := ""
+	if node := bootstrap.GetNodeRecord(zeroConfClient, *clusterId, *consulNodeName); node != nil {
+		address = node.Address
+	}
+
+	// Nodes registered before the current name encoding may still use their legacy service ID.
+	serviceIds, err := bootstrap.NodeServiceIds(zeroConfClient, *consulNodeName, address)
+	if err != nil {
 		log.Fatal(err)
 	}
 
+	if len(serviceIds) == 0 {
+		serviceIds = []string{bootstrap.SanitizeNodeName(*consulNodeName)}
+	}
+
+	for _, serviceId := range serviceIds {
+		if err := agentClient.ServiceDeregister(serviceId); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	log.Printf("==> Node %s deregistered with ZeroConf Server", *consulNodeName)
+
+	if *decommission {
+		DecommissionNode(zeroConfClient, config, retries, delay)
+	}
+}
+
+// DecommissionNode removes every trace of the node from its cluster and the ZeroConf Server.
+// All steps are attempted and reported, the command fails if any of them did.
+func DecommissionNode(zeroConfClient *consul.ConsulClient, config *consulApi.Config, retries, delay int) {
+	clusterConfig := *config
+	if *bootstrapToken != "" {
+		clusterConfig.Token = *bootstrapToken
+	}
+	consulClient := ConnectConsulServer(&clusterConfig, retries, delay)
+
+	node := bootstrap.GetNodeRecord(zeroConfClient, *clusterId, *consulNodeName)
+	role, nodeClusterId := *nodeRole, *clusterId
+	if node != nil {
+		role, nodeClusterId = node.Role, node.ClusterId
+	}
+
+	log.Printf("==> Decommissioning %s (%s) from cluster %s.", *consulNodeName, role, nodeClusterId)
+
+	failed := false
+	step := func(name string, run func() (string, error)) {
+		result, err := run()
+		if err != nil {
+			failed = true
+			log.Printf("==>   %-22s FAILED: %s", name, err)
+			return
+		}
+		log.Printf("==>   %-22s %s", name, result)
+	}
+
+	// Servers are removed from raft before leaving, a graceful leave removes the peer anyway.
+	if role == bootstrap.ROLE_SERVER && *forceLeave {
+		step("raft peer", func() (string, error) { return bootstrap.RemoveRaftPeer(consulClient, *consulNodeName) })
+	}
+
+	step("ACL token & policy", func() (string, error) {
+		return bootstrap.RevokeNodeToken(consulClient, node, *consulNodeName, *consulNodePrefix)
+	})
+	step("cluster membership", func() (string, error) { return bootstrap.LeaveCluster(consulClient, *consulNodeName, *forceLeave) })
+
+	if role == bootstrap.ROLE_SERVER {
+		step("server slot", func() (string, error) {
+			return bootstrap.ReleaseServerSlot(zeroConfClient, nodeClusterId, *consulNodeName)
+		})
+	}
+
+	step("KV records", func() (string, error) {
+		return bootstrap.DeleteNodeRecords(zeroConfClient, nodeClusterId, *consulNodeName)
+	})
+
+	// Last, as -zeroconf-token may be one of the registration tokens it deletes.
+	step("registration policy", func() (string, error) { return bootstrap.RevokeNodeRegistration(zeroConfClient, *consulNodeName) })
+
+	if failed {
+		log.Fatalf("==> Decommission of %s did not complete. Re-run once the failed steps can succeed.", *consulNodeName)
+	}
+
+	log.Printf("==> Node %s decommissioned.", *consulNodeName)
 }
 
 func ConnectConsulServer(config *consulApi.Config, retries, delay int) *consul.ConsulClient {
@@ -121,10 +409,21 @@ func ConnectConsulServer(config *consulApi.Config, retries, delay int) *consul.C
 	return consulClient
 }
 
+// ConnectZeroConfServer connects with the -zeroconf-* settings only. The CONSUL_* environment describes the local
+// cluster, so its CA or client certificate must never be used to trust the ZeroConf Server.
 func ConnectZeroConfServer(address, token string, retries, delay int) *consul.ConsulClient {
-	config := consulApi.DefaultConfig()
-	config.Address = address
-	config.Token = token
+	config := consul.NewExplicitConfig(address, token)
+	ApplyTlsConfig(&config.TLSConfig, *zeroConfCaFile, *zeroConfClientCert, *zeroConfClientKey, *zeroConfTlsServerName)
+
+	if *zeroConfFingerprint != "" {
+		if err := consul.PinCAFingerprint(config, *zeroConfFingerprint); err != nil {
+			log.Fatalf("==> Invalid -zeroconf-ca-fingerprint: %s", err)
+		}
+	}
+
+	if err := consul.BuildHttpClient(config); err != nil {
+		log.Fatalf("==> Invalid ZeroConf Server TLS settings: %s", err)
+	}
 
 	client, err := consul.ConnectConsulWithRetry(config, retries, delay)
 	if err != nil {
@@ -138,5 +437,23 @@ func ConnectZeroConfServer(address, token string, retries, delay int) *consul.Co
 		Token:      config.Token,
 	}
 
+	WaitForLeader(consulClient, retries, delay)
+
 	return consulClient
 }
+
+// ApplyTlsConfig overrides the TLS settings of tlsConfig with the explicit values that are set.
+func ApplyTlsConfig(tlsConfig *consulApi.TLSConfig, caFile, certFile, keyFile, serverName string) {
+	if caFile != "" {
+		tlsConfig.CAFile = caFile
+	}
+
+	if certFile != "" {
+		tlsConfig.CertFile = certFile
+		tlsConfig.KeyFile = keyFile
+	}
+
+	if serverName != "" {
+		tlsConfig.Address = serverName
+	}
+}
@@ -0,0 +1,135 @@
+package bootstrap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+	"redserenity.com/consul-bootstrap/jwt"
+	"redserenity.com/consul-bootstrap/secret"
+)
+
+const AUTO_CONFIG_ISSUER = "consul-zeroconf"
+
+func AutoConfigKeyPath(clusterId string) string {
+	return ClusterPath(clusterId) + "auto_config/signing_key"
+}
+
+func AutoConfigAudience(clusterId string) string {
+	return "consul-cluster-" + clusterId
+}
+
+// FetchAutoConfigKey reads the JWT signing key of the cluster from the ZeroConf server, generating it first if missing.
+func FetchAutoConfigKey(client *consul.ConsulClient, clusterId, encryptionKey string) *jwt.SigningKey {
+	for {
+		pair, err := consul.GetKVPair(client, AutoConfigKeyPath(clusterId))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if pair != nil {
+			key := &jwt.SigningKey{}
+			if err := json.Unmarshal(pair.Value, key); err != nil {
+				log.Fatal(err)
+			}
+
+			if key.PrivateKeyPEM, err = secret.Decrypt(encryptionKey, key.PrivateKeyPEM); err != nil {
+				log.Fatal(err)
+			}
+
+			return key
+		}
+
+		log.Printf("==> Generating auto_config JWT signing key for cluster %s.", clusterId)
+
+		key, err := jwt.GenerateSigningKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sealed := &jwt.SigningKey{PublicKeyPEM: key.PublicKeyPEM, PrivateKeyPEM: sealSecret("JWT signing key", key.PrivateKeyPEM, encryptionKey)}
+		content, err := json.Marshal(sealed)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		created, err := consul.CreateKV(client, AutoConfigKeyPath(clusterId), string(content))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if created {
+			return key
+		}
+	}
+}
+
+// SaveAutoConfigAuthorizer writes the server side auto_config authorizer validating intro tokens signed with key.
+func SaveAutoConfigAuthorizer(key *jwt.SigningKey, clusterId, path, name string) {
+	log.Printf("==> Saving auto_config authorizer in %s%s.", path, config.AgentConfigFile(name))
+
+	_, err := config.SaveAgentConfig(path, name, &config.AgentConfig{
+		AutoConfig: &config.AutoConfig{
+			Authorization: &config.AutoConfigAuthorization{
+				Enabled: config.Bool(true),
+				Static: &config.AutoConfigAuthorizer{
+					JWTValidationPubKeys: []string{strings.TrimSpace(key.PublicKeyPEM)},
+					BoundIssuer:          AUTO_CONFIG_ISSUER,
+					BoundAudiences:       []string{AutoConfigAudience(clusterId)},
+					ClaimMappings:        map[string]string{"sub": "node_name"},
+					ClaimAssertions:      []string{`value.node_name == "${node}"`},
+				},
+			},
+		},
+	}, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// MintIntroToken signs a JWT that allows nodeName to request its configuration from the cluster servers.
+func MintIntroToken(key *jwt.SigningKey, clusterId, nodeName string, ttl time.Duration) string {
+	log.Printf("==> Minting auto_config intro token for %s.", nodeName)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	token, err := jwt.Sign(key.PrivateKeyPEM, &jwt.Claims{
+		Issuer:    AUTO_CONFIG_ISSUER,
+		Subject:   nodeName,
+		Audience:  []string{AutoConfigAudience(clusterId)},
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Add(-time.Minute).Unix(),
+		Expiry:    now.Add(ttl).Unix(),
+		ID:        hex.EncodeToString(id),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return token
+}
+
+// SaveAutoConfigClient writes the client auto_config stanza. The intro token is a credential, so the file is only readable by its owner.
+func SaveAutoConfigClient(introToken string, serverAddresses []string, path, name string) {
+	log.Printf("==> Saving auto_config client configuration in %s%s.", path, config.AgentConfigFile(name))
+
+	_, err := config.SaveAgentConfig(path, name, &config.AgentConfig{
+		AutoConfig: &config.AutoConfig{
+			Enabled:         config.Bool(true),
+			IntroToken:      introToken,
+			ServerAddresses: serverAddresses,
+		},
+	}, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
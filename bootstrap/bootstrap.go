@@ -3,9 +3,7 @@ package bootstrap
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"log"
-	"strings"
 
 	consulApi "github.com/hashicorp/consul/api"
 	"redserenity.com/consul-bootstrap/config"
@@ -29,18 +27,6 @@ func Bootstrap(consulClient *consul.ConsulClient, retries, delay int) *consulApi
 	return token
 }
 
-func SaveBootstrapKey(client *consul.ConsulClient, key string, bootstrapToken *consulApi.ACLToken) {
-	log.Printf("==> Saving Bootstrap token to KV store.")
-
-	if err := consul.SaveKVStruct(client, "bootstrap/"+key+"/complete", bootstrapToken); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := consul.SaveKV(client, "bootstrap/"+key+"/token", bootstrapToken.SecretID); err != nil {
-		log.Fatal(err)
-	}
-}
-
 func SetupAnonPolicies(client *consul.ConsulClient) {
 	log.Printf("==> Updating Anonymous token with sane defaults.")
 
@@ -61,18 +47,18 @@ func SetupAnonPolicies(client *consul.ConsulClient) {
 func SetupNodePolicy(client *consul.ConsulClient, nodeName string, nodePrefix string) *consulApi.ACLToken {
 	log.Printf("==> Creating Node policy for %s.", nodeName)
 
-	safeNodeName := SanitizeNodeName(nodeName)
-	template, err := config.GetTemplate("NodePolicy", templates.NODE_POLICY, struct{ Name string }{Name: safeNodeName})
+	template, err := config.GetTemplate("NodePolicy", templates.NODE_POLICY, struct{ Name string }{Name: nodeName})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	policy, err := consul.CreatePolicy(client, nodePrefix+safeNodeName, "Agent Policy for node "+nodeName, template)
+	policyName := NodePolicyName(nodePrefix, nodeName)
+	policy, err := consul.CreatePolicy(client, policyName, NodePolicyDescription(nodeName), template)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	token, err := consul.CreatePolicyToken(client, "Agent Token for policy "+nodePrefix+safeNodeName, policy)
+	token, err := consul.CreatePolicyToken(client, "Agent Token for policy "+policyName, policy)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -80,19 +66,32 @@ func SetupNodePolicy(client *consul.ConsulClient, nodeName string, nodePrefix st
 	return token
 }
 
-func SetupRegisterToken(client *consul.ConsulClient) *consulApi.ACLToken {
-	log.Printf("==> Creating registration policy & token.")
+// ClusterPath is the KV prefix holding everything the ZeroConf server keeps for a cluster. The registration tokens
+// of the cluster can read and write it, so secrets below it are only stored sealed with -encryption-key.
+func ClusterPath(clusterId string) string {
+	return "clusters/" + clusterId + "/"
+}
+
+func RegistrationPolicyName(clusterId string) string {
+	return "cluster-registration-" + clusterId
+}
+
+// SetupRegisterToken creates the token nodes of a cluster register with. It is limited to the cluster's own records.
+func SetupRegisterToken(client *consul.ConsulClient, clusterId string) *consulApi.ACLToken {
+	log.Printf("==> Creating registration policy & token for cluster %s.", clusterId)
+
+	policyName := RegistrationPolicyName(clusterId)
 
 	policy, err := consul.CreatePolicy(
 		client,
-		"cluster-registration",
-		"Policy for cluster nodes to register with the ZeroConf server",
-		templates.REGISTRATION_POLICY)
+		policyName,
+		"Policy for nodes of cluster "+clusterId+" to register with the ZeroConf server",
+		registrationRules(client, clusterId))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	token, err := consul.CreatePolicyToken(client, "Registration Token for policy cluster-registration", policy)
+	token, err := consul.CreatePolicyToken(client, "Registration Token for policy "+policyName, policy)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -100,64 +99,46 @@ func SetupRegisterToken(client *consul.ConsulClient) *consulApi.ACLToken {
 	return token
 }
 
-func SaveRegisterToken(token *consulApi.ACLToken, address, path, file string) {
-	log.Printf("==> Saving registration token in %s%s.", path, file)
-
-	content, err := json.MarshalIndent(&ZeroConf{Address: address, Token: token.SecretID}, "", "\t")
+// registrationRules renders a registration policy. Bootstrap locks are sessions on the ZeroConf server's own node,
+// so that is the only node the policy may create sessions on.
+func registrationRules(client *consul.ConsulClient, clusterId string) string {
+	serverNode, err := consul.GetAgentNodeName(client)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := config.SaveConfig(path, file, string(content)); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func UpdateAclConfig(nodeToken *consulApi.ACLToken, path, file string) {
-	log.Printf("==> Updating acl config in %s%s.", path, file)
-
-	template, err := config.GetTemplate("AclConfig", templates.ACL_CONFIG, nodeToken)
+	rules, err := config.GetTemplate("RegistrationPolicy", templates.REGISTRATION_POLICY, struct{ ClusterId, ServerNode string }{ClusterId: clusterId, ServerNode: serverNode})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := config.SaveConfig(path, file, template); err != nil {
-		log.Fatal(err)
-	}
+	return rules
 }
 
-func SetupClusterKV(client *consul.ConsulClient) {
-	log.Printf("==> Setting up cluster structure in KV store.")
-
-	if err := consul.SaveKV(client, "bootstrap/cluster/complete", "{}"); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := consul.SaveKV(client, "bootstrap/cluster/token", ""); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := consul.SaveKVStruct(client, "cluster/nodes/", ""); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := consul.SaveKV(client, "cluster/gossip_key", GenerateKey()); err != nil {
+func UpdateAclConfig(nodeToken *consulApi.ACLToken, path, name string) {
+	log.Printf("==> Updating acl config in %s%s.", path, config.AgentConfigFile(name))
+
+	err := config.MergeAgentConfig(path, name, &config.AgentConfig{
+		ACL: &config.ACLConfig{
+			Enabled:                config.Bool(true),
+			DefaultPolicy:          "deny",
+			EnableTokenPersistence: config.Bool(true),
+			Tokens:                 &config.ACLTokensConfig{Agent: nodeToken.SecretID},
+		},
+	})
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func LockDownNodeJoining(path, file string) {
+func LockDownNodeJoining(gossipKey, path, name string) {
 	log.Printf("==> Locking down the node from (possible) rogue nodes.")
 
-	if err := config.SaveConfig(path, file, "encrypt = \""+GenerateKey()+"\""); err != nil {
+	if err := config.MergeAgentConfig(path, name, &config.AgentConfig{Encrypt: gossipKey}); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func SanitizeNodeName(nodeName string) string {
-	return strings.Replace(nodeName, ".", "_", -1)
-}
-
 func GenerateKey() string {
 	key := make([]byte, 32)
 	n, err := rand.Reader.Read(key)
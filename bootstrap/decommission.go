@@ -0,0 +1,167 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// LeaveCluster makes the node leave its cluster. A node can only leave gracefully through its own agent,
+// through any other agent it is force-left and pruned from the member list.
+func LeaveCluster(client *consul.ConsulClient, nodeName string, force bool) (string, error) {
+	agentName, err := client.Client.Agent().NodeName()
+	if err != nil {
+		return "", err
+	}
+
+	if agentName == nodeName && !force {
+		if err := client.Client.Agent().Leave(); err != nil {
+			return "", err
+		}
+		return "left the cluster gracefully", nil
+	}
+
+	if err := client.Client.Agent().ForceLeavePrune(nodeName); err != nil {
+		return "", err
+	}
+
+	return "force-left and pruned through " + agentName, nil
+}
+
+func RemoveRaftPeer(client *consul.ConsulClient, nodeName string) (string, error) {
+	removed, err := consul.RemoveRaftPeer(client, nodeName)
+	if err != nil {
+		return "", err
+	}
+
+	if !removed {
+		return "not a raft peer", nil
+	}
+
+	return "removed from the raft configuration", nil
+}
+
+// RevokeNodeToken deletes the node's ACL token and its Node-<name> policy. When the inventory has
+// no token accessor, every token linked to the policy is deleted instead.
+func RevokeNodeToken(client *consul.ConsulClient, node *ClusterNode, nodeName, nodePrefix string) (string, error) {
+	if err := MigrateNodePolicy(client, nodePrefix, nodeName); err != nil {
+		return "", err
+	}
+
+	policyName := NodePolicyName(nodePrefix, nodeName)
+
+	var accessors []string
+	if node != nil && node.TokenAccessor != "" {
+		accessors = append(accessors, node.TokenAccessor)
+	} else {
+		tokens, err := consul.ListTokensByPolicy(client, policyName)
+		if err != nil {
+			return "", err
+		}
+
+		for _, token := range tokens {
+			accessors = append(accessors, token.AccessorID)
+		}
+	}
+
+	for _, accessor := range accessors {
+		if err := consul.DeleteToken(client, accessor); err != nil {
+			return "", err
+		}
+	}
+
+	if err := consul.DeletePolicyByName(client, policyName); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("deleted %d token(s) and policy %s", len(accessors), policyName), nil
+}
+
+// RevokeNodeRegistration deletes the zeroconf-node-<name> registration policy of a node that joined with a ticket,
+// and the registration tokens linked to it, from the ZeroConf server.
+func RevokeNodeRegistration(zeroConfClient *consul.ConsulClient, nodeName string) (string, error) {
+	policyName := NodeRegistrationPolicyName(nodeName)
+
+	if err := migrateLegacyPolicy(zeroConfClient, "zeroconf-node-"+LegacyNodeName(nodeName), policyName, NodeRegistrationPolicyDescription(nodeName)); err != nil {
+		return "", err
+	}
+
+	policy, err := consul.GetPolicyByName(zeroConfClient, policyName)
+	if consul.IsPermissionDenied(err) {
+		return "", fmt.Errorf("%s. Deleting %s requires a -zeroconf-token with acl write, not the node's registration token", err, policyName)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if policy == nil {
+		return "no registration policy", nil
+	}
+
+	tokens, err := consul.ListTokensByPolicy(zeroConfClient, policyName)
+	if err != nil {
+		return "", err
+	}
+
+	for _, token := range tokens {
+		if err := consul.DeleteToken(zeroConfClient, token.AccessorID); err != nil {
+			return "", err
+		}
+	}
+
+	if err := consul.DeletePolicyByName(zeroConfClient, policyName); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("deleted %d registration token(s) and policy %s", len(tokens), policyName), nil
+}
+
+// ReleaseServerSlot removes nodeName from the cluster's claimed server slots so a replacement server can register.
+func ReleaseServerSlot(client *consul.ConsulClient, clusterId, nodeName string) (string, error) {
+	for {
+		pair, err := consul.GetKVPair(client, ClusterServersPath(clusterId))
+		if err != nil {
+			return "", err
+		}
+
+		if pair == nil {
+			return "no server slots claimed", nil
+		}
+
+		servers := &ClusterServers{}
+		if err := json.Unmarshal(pair.Value, servers); err != nil {
+			return "", err
+		}
+
+		remaining := []string{}
+		for _, server := range servers.Servers {
+			if server != nodeName {
+				remaining = append(remaining, server)
+			}
+		}
+
+		if len(remaining) == len(servers.Servers) {
+			return "held no server slot", nil
+		}
+
+		servers.Servers = remaining
+
+		saved, err := consul.SaveKVStructCAS(client, ClusterServersPath(clusterId), servers, pair.ModifyIndex)
+		if err != nil {
+			return "", err
+		}
+
+		if saved {
+			return fmt.Sprintf("released server slot (%d of %d claimed)", len(servers.Servers), servers.Expect), nil
+		}
+	}
+}
+
+func DeleteNodeRecords(client *consul.ConsulClient, clusterId, nodeName string) (string, error) {
+	if err := consul.DeleteKVTree(client, NodePath(clusterId, nodeName)); err != nil {
+		return "", err
+	}
+
+	return "deleted " + NodePath(clusterId, nodeName), nil
+}
@@ -0,0 +1,158 @@
+package bootstrap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRevokeNodeToken(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	fake.addPolicy("Node-node1", NodePolicyDescription("node1"))
+	fake.addPolicy("Node-node2", NodePolicyDescription("node2"))
+	fake.addToken("node1-current", "Node-node1")
+	fake.addToken("node1-stale", "Node-node1")
+	fake.addToken("node2", "Node-node2")
+
+	// With an inventory record only the recorded token is deleted.
+	result, err := RevokeNodeToken(client, &ClusterNode{Name: "node1", TokenAccessor: "node1-current"}, "node1", "Node-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "deleted 1 token(s) and policy Node-node1" {
+		t.Fatalf("result is %q", result)
+	}
+	if ids := fake.tokenIds(); !reflect.DeepEqual(ids, []string{"node1-stale", "node2"}) {
+		t.Fatalf("tokens left are %v", ids)
+	}
+	if names := fake.policyNames(); !reflect.DeepEqual(names, []string{"Node-node2"}) {
+		t.Fatalf("policies left are %v", names)
+	}
+}
+
+func TestRevokeNodeTokenWithoutRecord(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	// A policy under the legacy name is found through its description.
+	fake.addPolicy("Node-web_1", NodePolicyDescription("web.1"))
+	fake.addToken("first", "Node-web_1")
+	fake.addToken("second", "Node-web_1")
+	fake.addToken("other", "Node-web_2")
+
+	if _, err := RevokeNodeToken(client, nil, "web.1", "Node-"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := fake.tokenIds(); !reflect.DeepEqual(ids, []string{"other"}) {
+		t.Fatalf("tokens left are %v", ids)
+	}
+	if names := fake.policyNames(); len(names) != 0 {
+		t.Fatalf("policies left are %v", names)
+	}
+}
+
+func TestRevokeNodeRegistration(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	fake.addPolicy(NodeRegistrationPolicyName("node1"), NodeRegistrationPolicyDescription("node1"))
+	fake.addPolicy(RegistrationPolicyName("web"), "")
+	fake.addToken("ticket1", NodeRegistrationPolicyName("node1"))
+	fake.addToken("ticket2", NodeRegistrationPolicyName("node1"))
+	fake.addToken("cluster", RegistrationPolicyName("web"))
+
+	result, err := RevokeNodeRegistration(client, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "deleted 2 registration token(s) and policy zeroconf-node-node1" {
+		t.Fatalf("result is %q", result)
+	}
+
+	if ids := fake.tokenIds(); !reflect.DeepEqual(ids, []string{"cluster"}) {
+		t.Fatalf("tokens left are %v", ids)
+	}
+	if names := fake.policyNames(); !reflect.DeepEqual(names, []string{RegistrationPolicyName("web")}) {
+		t.Fatalf("policies left are %v", names)
+	}
+
+	// Nodes that did not join with a ticket have no registration policy.
+	if result, err := RevokeNodeRegistration(client, "node1"); err != nil || result != "no registration policy" {
+		t.Fatalf("second revoke returned %q, %v", result, err)
+	}
+}
+
+func TestReleaseServerSlot(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+	fake.put("clusters/web/servers", &ClusterServers{Expect: 3, Servers: []string{"server1", "server2"}})
+
+	result, err := ReleaseServerSlot(client, "web", "server1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "released server slot (1 of 3 claimed)" {
+		t.Fatalf("result is %q", result)
+	}
+
+	servers := &ClusterServers{}
+	fake.get("clusters/web/servers", servers)
+	if !reflect.DeepEqual(servers, &ClusterServers{Expect: 3, Servers: []string{"server2"}}) {
+		t.Fatalf("servers are %+v", servers)
+	}
+
+	if result, _ := ReleaseServerSlot(client, "web", "server1"); result != "held no server slot" {
+		t.Fatalf("second release returned %q", result)
+	}
+	if result, _ := ReleaseServerSlot(client, "db", "server1"); result != "no server slots claimed" {
+		t.Fatalf("release in a cluster without servers returned %q", result)
+	}
+}
+
+func TestDeleteNodeRecords(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	SaveNodeRecord(client, &ClusterNode{Name: "node1", ClusterId: "web"})
+	SaveNodeRecord(client, &ClusterNode{Name: "node1", ClusterId: "db"})
+	SaveNodeToken(client, "web", "node1", "secret", "passphrase")
+
+	if _, err := DeleteNodeRecords(client, "web", "node1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := fake.keys(); !reflect.DeepEqual(keys, []string{"clusters/db/nodes/node1/record"}) {
+		t.Fatalf("keys left are %v", keys)
+	}
+}
+
+func TestLeaveCluster(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	cases := []struct {
+		node   string
+		force  bool
+		result string
+	}{
+		{"zeroconf", false, "left the cluster gracefully"},
+		{"zeroconf", true, "force-left and pruned through zeroconf"},
+		{"node1", false, "force-left and pruned through zeroconf"},
+	}
+
+	for _, c := range cases {
+		result, err := LeaveCluster(client, c.node, c.force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.result {
+			t.Fatalf("%s (force %t) returned %q, want %q", c.node, c.force, result, c.result)
+		}
+	}
+
+	if want := []string{"zeroconf", "zeroconf", "node1"}; !reflect.DeepEqual(fake.left, want) {
+		t.Fatalf("nodes that left are %v, want %v", fake.left, want)
+	}
+}
@@ -0,0 +1,557 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// fakeConsul serves the parts of the Consul HTTP API the bootstrap package uses, backed by memory.
+// Blocking queries return after a short wait so tests do not hang on them.
+type fakeConsul struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	index    uint64
+	kv       map[string]*consulApi.KVPair
+	sessions map[string]bool
+
+	policies map[string]*consulApi.ACLPolicy
+	tokens   map[string]*consulApi.ACLToken
+
+	services map[string]*consulApi.AgentService
+	checks   map[string]*consulApi.HealthCheck
+
+	// nodeName is the agent's node, left records the nodes that left through it.
+	nodeName string
+	left     []string
+
+	// members is the size of the LAN pool, keyring the members each gossip key is installed on.
+	members    int
+	keyring    map[string]int
+	primaryKey string
+}
+
+func newFakeConsul(t *testing.T) *fakeConsul {
+	fake := &fakeConsul{
+		t:        t,
+		index:    1,
+		kv:       map[string]*consulApi.KVPair{},
+		sessions: map[string]bool{},
+		policies: map[string]*consulApi.ACLPolicy{},
+		tokens:   map[string]*consulApi.ACLToken{},
+		services: map[string]*consulApi.AgentService{},
+		checks:   map[string]*consulApi.HealthCheck{},
+		nodeName: "zeroconf",
+		members:  1,
+		keyring:  map[string]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) { fake.reply(w, "127.0.0.1:8300") })
+	mux.HandleFunc("/v1/kv/", fake.handleKV)
+	mux.HandleFunc("/v1/session/", fake.handleSession)
+	mux.HandleFunc("/v1/operator/keyring", fake.handleKeyring)
+	mux.HandleFunc("/v1/acl/policy/", fake.handlePolicy)
+	mux.HandleFunc("/v1/acl/tokens", fake.handleTokenList)
+	mux.HandleFunc("/v1/acl/token/", fake.handleToken)
+	mux.HandleFunc("/v1/agent/check/pass/", fake.handlePassCheck)
+	mux.HandleFunc("/v1/agent/service/deregister/", fake.handleDeregister)
+	mux.HandleFunc("/v1/health/service/", fake.handleHealthService)
+	mux.HandleFunc("/v1/agent/self", func(w http.ResponseWriter, r *http.Request) {
+		fake.reply(w, map[string]map[string]interface{}{"Config": {"NodeName": fake.nodeName, "Datacenter": "dc1"}})
+	})
+	mux.HandleFunc("/v1/agent/leave", func(w http.ResponseWriter, r *http.Request) { fake.leave(fake.nodeName) })
+	mux.HandleFunc("/v1/agent/force-leave/", func(w http.ResponseWriter, r *http.Request) {
+		fake.leave(strings.TrimPrefix(r.URL.Path, "/v1/agent/force-leave/"))
+	})
+
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+
+	return fake
+}
+
+func (fake *fakeConsul) client() *consul.ConsulClient {
+	config := consulApi.DefaultConfig()
+	config.Address = fake.server.URL
+
+	client, err := consulApi.NewClient(config)
+	if err != nil {
+		fake.t.Fatal(err)
+	}
+
+	return &consul.ConsulClient{Client: client}
+}
+
+func (fake *fakeConsul) reply(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		fake.t.Error(err)
+	}
+}
+
+func (fake *fakeConsul) body(r *http.Request) []byte {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fake.t.Error(err)
+	}
+	return content
+}
+
+// waitIndex blocks a query with an index until the data changed or a short wait passed.
+func (fake *fakeConsul) waitIndex(r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if index == 0 {
+		return
+	}
+
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		fake.mu.Lock()
+		changed := fake.index > index
+		fake.mu.Unlock()
+
+		if changed {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (fake *fakeConsul) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	query := r.URL.Query()
+	_, recurse := query["recurse"]
+
+	if r.Method == http.MethodGet {
+		fake.waitIndex(r)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(fake.index, 10))
+
+	switch r.Method {
+	case http.MethodGet:
+		var pairs consulApi.KVPairs
+		for _, pair := range fake.kv {
+			if pair.Key == key || (recurse && strings.HasPrefix(pair.Key, key)) {
+				copied := *pair
+				pairs = append(pairs, &copied)
+			}
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fake.reply(w, pairs)
+
+	case http.MethodPut:
+		existing := fake.kv[key]
+		if cas, ok := query["cas"]; ok {
+			index, _ := strconv.ParseUint(cas[0], 10, 64)
+			if (index == 0 && existing != nil) || (index != 0 && (existing == nil || existing.ModifyIndex != index)) {
+				fake.reply(w, false)
+				return
+			}
+		}
+
+		pair := &consulApi.KVPair{Key: key, Value: fake.body(r), CreateIndex: fake.index + 1}
+		if existing != nil {
+			pair.CreateIndex = existing.CreateIndex
+			pair.Session = existing.Session
+		}
+		pair.Flags, _ = strconv.ParseUint(query.Get("flags"), 10, 64)
+
+		if session := query.Get("acquire"); session != "" {
+			if !fake.sessions[session] || (pair.Session != "" && pair.Session != session) {
+				fake.reply(w, false)
+				return
+			}
+			pair.Session = session
+		}
+		if session := query.Get("release"); session != "" {
+			if pair.Session != session {
+				fake.reply(w, false)
+				return
+			}
+			pair.Session = ""
+		}
+
+		fake.index++
+		pair.ModifyIndex = fake.index
+		fake.kv[key] = pair
+		fake.reply(w, true)
+
+	case http.MethodDelete:
+		for existing := range fake.kv {
+			if existing == key || (recurse && strings.HasPrefix(existing, key)) {
+				delete(fake.kv, existing)
+			}
+		}
+		fake.index++
+		fake.reply(w, true)
+	}
+}
+
+func (fake *fakeConsul) handleSession(w http.ResponseWriter, r *http.Request) {
+	action := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/session/"), "/")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	switch action[0] {
+	case "create":
+		fake.index++
+		id := fmt.Sprintf("session-%d", fake.index)
+		fake.sessions[id] = true
+		fake.reply(w, map[string]string{"ID": id})
+
+	case "renew":
+		if !fake.sessions[action[1]] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fake.reply(w, []*consulApi.SessionEntry{{ID: action[1], TTL: "30s"}})
+
+	case "destroy":
+		delete(fake.sessions, action[1])
+		for _, pair := range fake.kv {
+			if pair.Session == action[1] {
+				pair.Session = ""
+				fake.index++
+				pair.ModifyIndex = fake.index
+			}
+		}
+		fake.reply(w, true)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleKeyring installs keys on every member at once, tests lower the count to simulate members catching up.
+func (fake *fakeConsul) handleKeyring(w http.ResponseWriter, r *http.Request) {
+	var body struct{ Key string }
+	if r.Method != http.MethodGet {
+		if err := json.Unmarshal(fake.body(r), &body); err != nil {
+			fake.t.Error(err)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		keys := map[string]int{}
+		for key, installed := range fake.keyring {
+			keys[key] = installed
+		}
+		fake.reply(w, []*consulApi.KeyringResponse{{
+			Datacenter:  "dc1",
+			Keys:        keys,
+			PrimaryKeys: map[string]int{fake.primaryKey: fake.members},
+			NumNodes:    fake.members,
+		}})
+	case http.MethodPost:
+		fake.keyring[body.Key] = fake.members
+	case http.MethodPut:
+		if fake.keyring[body.Key] == 0 {
+			http.Error(w, "key is not installed", http.StatusInternalServerError)
+			return
+		}
+		fake.primaryKey = body.Key
+	case http.MethodDelete:
+		if body.Key == fake.primaryKey {
+			http.Error(w, "removing the primary key is not allowed", http.StatusInternalServerError)
+			return
+		}
+		delete(fake.keyring, body.Key)
+	}
+}
+
+func (fake *fakeConsul) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/acl/policy/")
+
+	var update consulApi.ACLPolicy
+	if r.Method == http.MethodPut {
+		if err := json.Unmarshal(fake.body(r), &update); err != nil {
+			fake.t.Error(err)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if strings.HasPrefix(id, "name/") {
+		for _, policy := range fake.policies {
+			if policy.Name == strings.TrimPrefix(id, "name/") {
+				fake.reply(w, policy)
+				return
+			}
+		}
+		http.Error(w, "ACL not found", http.StatusNotFound)
+		return
+	}
+
+	if _, ok := fake.policies[id]; !ok {
+		http.Error(w, "ACL not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fake.reply(w, fake.policies[id])
+	case http.MethodPut:
+		update.ID = id
+		fake.policies[id] = &update
+		fake.reply(w, &update)
+	case http.MethodDelete:
+		delete(fake.policies, id)
+		fake.reply(w, true)
+	}
+}
+
+func (fake *fakeConsul) handleTokenList(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	var entries []*consulApi.ACLTokenListEntry
+	for _, token := range fake.tokens {
+		// Tokens link policies by ID, so a renamed policy is listed under its new name.
+		entry := &consulApi.ACLTokenListEntry{AccessorID: token.AccessorID}
+		for _, link := range token.Policies {
+			name := link.Name
+			if policy, ok := fake.policies[link.ID]; ok {
+				name = policy.Name
+			}
+			entry.Policies = append(entry.Policies, &consulApi.ACLTokenPolicyLink{ID: link.ID, Name: name})
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].AccessorID < entries[j].AccessorID })
+
+	fake.reply(w, entries)
+}
+
+func (fake *fakeConsul) handleToken(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/acl/token/")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if _, ok := fake.tokens[id]; !ok || r.Method != http.MethodDelete {
+		http.Error(w, "ACL not found", http.StatusNotFound)
+		return
+	}
+
+	delete(fake.tokens, id)
+	fake.reply(w, true)
+}
+
+func (fake *fakeConsul) handlePassCheck(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/pass/")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	check, ok := fake.checks[id]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown check %q", id), http.StatusNotFound)
+		return
+	}
+
+	check.Status = consulApi.HealthPassing
+	check.Output = r.URL.Query().Get("note")
+}
+
+func (fake *fakeConsul) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	delete(fake.services, id)
+	for checkId, check := range fake.checks {
+		if check.ServiceID == id {
+			delete(fake.checks, checkId)
+		}
+	}
+}
+
+func (fake *fakeConsul) handleHealthService(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	entries := []*consulApi.ServiceEntry{}
+	for _, service := range fake.services {
+		if service.Service != name {
+			continue
+		}
+
+		entry := &consulApi.ServiceEntry{Node: &consulApi.Node{Node: fake.nodeName}, Service: service}
+		for _, check := range fake.checks {
+			if check.ServiceID == service.ID {
+				copied := *check
+				entry.Checks = append(entry.Checks, &copied)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Service.ID < entries[j].Service.ID })
+
+	fake.reply(w, entries)
+}
+
+// addClusterService registers a consul-cluster instance for nodeName, with a heartbeat check in status unless it is "".
+func (fake *fakeConsul) addClusterService(nodeName, status string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	id := SanitizeNodeName(nodeName)
+	fake.services[id] = &consulApi.AgentService{ID: id, Service: CLUSTER_SERVICE}
+	if status != "" {
+		fake.checks[HeartbeatCheckId(nodeName)] = &consulApi.HealthCheck{CheckID: HeartbeatCheckId(nodeName), ServiceID: id, Status: status}
+	}
+}
+
+// checkStatus returns the status of a check, or "" if it does not exist.
+func (fake *fakeConsul) checkStatus(checkId string) string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if check, ok := fake.checks[checkId]; ok {
+		return check.Status
+	}
+	return ""
+}
+
+func (fake *fakeConsul) leave(nodeName string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.left = append(fake.left, nodeName)
+}
+
+// addPolicy creates a policy and returns its ID.
+func (fake *fakeConsul) addPolicy(name, description string) string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.index++
+	id := fmt.Sprintf("policy-%d", fake.index)
+	fake.policies[id] = &consulApi.ACLPolicy{ID: id, Name: name, Description: description}
+
+	return id
+}
+
+// addToken creates a token linked to the named policies. Policies that do not exist are linked by name only.
+func (fake *fakeConsul) addToken(accessorId string, policyNames ...string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	token := &consulApi.ACLToken{AccessorID: accessorId, SecretID: accessorId + "-secret"}
+	for _, name := range policyNames {
+		link := &consulApi.ACLTokenPolicyLink{Name: name}
+		for id, policy := range fake.policies {
+			if policy.Name == name {
+				link.ID = id
+			}
+		}
+		token.Policies = append(token.Policies, link)
+	}
+	fake.tokens[accessorId] = token
+}
+
+// policyNames returns the names of the stored policies in order.
+func (fake *fakeConsul) policyNames() []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	var names []string
+	for _, policy := range fake.policies {
+		names = append(names, policy.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// tokenIds returns the accessor IDs of the stored tokens in order.
+func (fake *fakeConsul) tokenIds() []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	var ids []string
+	for id := range fake.tokens {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// put stores a KV entry directly, bypassing the API.
+func (fake *fakeConsul) put(key string, value interface{}) {
+	content, ok := value.([]byte)
+	if !ok {
+		var err error
+		if content, err = json.Marshal(value); err != nil {
+			fake.t.Fatal(err)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.index++
+	fake.kv[key] = &consulApi.KVPair{Key: key, Value: content, CreateIndex: fake.index, ModifyIndex: fake.index}
+}
+
+// get decodes the stored JSON value of key into value.
+func (fake *fakeConsul) get(key string, value interface{}) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	pair, ok := fake.kv[key]
+	if !ok {
+		fake.t.Fatalf("%s is not stored", key)
+	}
+
+	if err := json.Unmarshal(pair.Value, value); err != nil {
+		fake.t.Fatal(err)
+	}
+}
+
+// keys returns the stored KV keys in order.
+func (fake *fakeConsul) keys() []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	var keys []string
+	for key := range fake.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
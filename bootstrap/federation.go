@@ -0,0 +1,328 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/certs"
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+	"redserenity.com/consul-bootstrap/secret"
+	"redserenity.com/consul-bootstrap/templates"
+)
+
+const REPLICATION_POLICY_NAME = "zeroconf-replication"
+
+func FederationPath(clusterId string) string {
+	return ClusterPath(clusterId) + "federation"
+}
+
+// ClusterServerEntries returns the healthy server instances of a cluster registered on the ZeroConf server.
+func ClusterServerEntries(zeroConfClient *consul.ConsulClient, clusterId string) []*consulApi.ServiceEntry {
+	entries, err := consul.GetHealthyServices(zeroConfClient, CLUSTER_SERVICE, ClusterTag(clusterId))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var servers []*consulApi.ServiceEntry
+	for _, entry := range entries {
+		if entry.Service.Meta["role"] == ROLE_SERVER && entry.Service.Address != "" {
+			servers = append(servers, entry)
+		}
+	}
+
+	return servers
+}
+
+// FetchClusterManagementToken reads the bootstrap token -bootstrap-cluster stored for the cluster.
+func FetchClusterManagementToken(zeroConfClient *consul.ConsulClient, clusterId, encryptionKey string) string {
+	token := GetClusterBootstrapToken(zeroConfClient, clusterId, encryptionKey)
+	if token == "" {
+		log.Fatalf("==> No bootstrap token stored for cluster %s. It is only stored when -bootstrap-cluster runs with -encryption-key.", clusterId)
+	}
+
+	return token
+}
+
+// SetupReplicationToken returns the ACL replication token secondary datacenters use against the primary. A token created by an
+// earlier run is reused, and any further replication tokens are revoked.
+func SetupReplicationToken(primaryClient *consul.ConsulClient) string {
+	policy, err := consul.GetPolicyByName(primaryClient, REPLICATION_POLICY_NAME)
+	if err != nil || policy == nil {
+		log.Printf("==> Creating ACL replication policy.")
+
+		policy, err = consul.CreatePolicy(primaryClient, REPLICATION_POLICY_NAME, "ACL replication for federated datacenters", templates.REPLICATION_POLICY)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	tokens, err := consul.ListTokensByPolicy(primaryClient, REPLICATION_POLICY_NAME)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var replicationToken string
+	for _, entry := range tokens {
+		if replicationToken == "" {
+			token, err := consul.GetToken(primaryClient, entry.AccessorID)
+			if err == nil && token != nil {
+				log.Printf("==> Reusing ACL replication token %s.", token.AccessorID)
+				replicationToken = token.SecretID
+				continue
+			}
+		}
+
+		log.Printf("==> Revoking ACL replication token %s.", entry.AccessorID)
+
+		if err := consul.DeleteToken(primaryClient, entry.AccessorID); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if replicationToken != "" {
+		return replicationToken
+	}
+
+	log.Printf("==> Creating ACL replication token.")
+
+	token, err := consul.CreatePolicyToken(primaryClient, "Replication Token for policy "+REPLICATION_POLICY_NAME, policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return token.SecretID
+}
+
+// MissingGlobalTokens returns the global tokens of a secondary datacenter the primary does not know. Once ACL token replication
+// is enabled, a secondary replaces its global tokens with those of the primary, so these tokens would be deleted.
+func MissingGlobalTokens(primaryClient, secondaryClient *consul.ConsulClient) []*consulApi.ACLTokenListEntry {
+	primaryTokens, err := consul.ListTokens(primaryClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	known := map[string]bool{}
+	for _, token := range primaryTokens {
+		known[token.AccessorID] = true
+	}
+
+	secondaryTokens, err := consul.ListTokens(secondaryClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var missing []*consulApi.ACLTokenListEntry
+	for _, token := range secondaryTokens {
+		if token.Local || token.Legacy || known[token.AccessorID] {
+			continue
+		}
+
+		missing = append(missing, token)
+	}
+
+	return missing
+}
+
+// MigrateGlobalTokens copies tokens of a secondary datacenter, with their secrets and policies, to the primary so they
+// survive ACL token replication. Policies that exist on the primary with different rules are not merged.
+func MigrateGlobalTokens(primaryClient, secondaryClient *consul.ConsulClient, tokens []*consulApi.ACLTokenListEntry) {
+	for _, entry := range tokens {
+		token, err := consul.GetToken(secondaryClient, entry.AccessorID)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(token.Roles) > 0 {
+			log.Fatalf("==> Token %s (%s) uses ACL roles, which are not migrated. Move it to the primary datacenter by hand.", token.AccessorID, token.Description)
+		}
+
+		var policies []*consulApi.ACLTokenPolicyLink
+		for _, link := range token.Policies {
+			migratePolicy(primaryClient, secondaryClient, link)
+			policies = append(policies, &consulApi.ACLTokenPolicyLink{Name: link.Name})
+		}
+
+		log.Printf("==> Migrating token %s (%s) to the primary datacenter.", token.AccessorID, token.Description)
+
+		_, err = consul.ImportToken(primaryClient, &consulApi.ACLToken{
+			AccessorID:        token.AccessorID,
+			SecretID:          token.SecretID,
+			Description:       token.Description,
+			Policies:          policies,
+			ServiceIdentities: token.ServiceIdentities,
+			NodeIdentities:    token.NodeIdentities,
+			ExpirationTime:    token.ExpirationTime,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func migratePolicy(primaryClient, secondaryClient *consul.ConsulClient, link *consulApi.ACLTokenPolicyLink) {
+	// Built-in policies such as global-management exist in every datacenter.
+	if strings.HasPrefix(link.ID, "00000000-0000-0000-0000-") {
+		return
+	}
+
+	policy, err := consul.GetPolicyById(secondaryClient, link.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	existing, err := consul.GetPolicyByName(primaryClient, policy.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if existing != nil {
+		if existing.Rules != policy.Rules {
+			log.Fatalf("==> Policy %s exists in both datacenters with different rules. Rename one of them before federating.", policy.Name)
+		}
+		return
+	}
+
+	log.Printf("==> Migrating policy %s to the primary datacenter.", policy.Name)
+
+	if _, err := consul.CreatePolicy(primaryClient, policy.Name, policy.Description, policy.Rules); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ClusterClientTls returns the TLS settings to reach the HTTPS API of a cluster's servers. The servers verify incoming
+// connections, so a short-lived client certificate is issued from the cluster CA.
+func ClusterClientTls(zeroConfClient *consul.ConsulClient, clusterId, datacenter, encryptionKey string) *consulApi.TLSConfig {
+	ca := GetClusterCA(zeroConfClient, clusterId, encryptionKey)
+	if ca == nil {
+		log.Fatalf("==> Servers of cluster %s serve HTTPS, but the cluster has no CA stored on the ZeroConf Server.", clusterId)
+	}
+
+	cert := IssueAgentCert(ca, "zeroconf-federation", datacenter, false, 1, certs.SystemClock{})
+
+	return &consulApi.TLSConfig{
+		Address: "server." + datacenter + ".consul",
+		CAPem:   []byte(ca.CertPEM),
+		CertPEM: []byte(cert.CertPEM),
+		KeyPEM:  []byte(cert.KeyPEM),
+	}
+}
+
+func WanAddresses(servers []*consulApi.ServiceEntry, serfWanPort int) []string {
+	var addresses []string
+	for _, server := range servers {
+		addresses = append(addresses, net.JoinHostPort(server.Service.Address, strconv.Itoa(serfWanPort)))
+	}
+
+	sort.Strings(addresses)
+	return addresses
+}
+
+// SaveClusterFederation stores the federation config of a cluster for its servers to pick up.
+func SaveClusterFederation(zeroConfClient *consul.ConsulClient, clusterId string, federation *FederationConfig, encryptionKey string) {
+	log.Printf("==> Saving federation config for cluster %s (primary datacenter %s).", clusterId, federation.PrimaryDatacenter)
+
+	content, err := json.Marshal(federation)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The registration tokens of the cluster can read the record, so the replication token is never stored in plaintext.
+	// Without a replication token there is nothing secret, but with -encryption-key every value is sealed
+	// so reading it back can refuse plaintext.
+	if federation.ReplicationToken != "" && encryptionKey == "" {
+		log.Fatalf("==> Refusing to store the ACL replication token of cluster %s unencrypted. Pass -encryption-key.", clusterId)
+	}
+
+	value := string(content)
+	if encryptionKey != "" {
+		value = sealSecret("Federation config", value, encryptionKey)
+	}
+
+	if err := consul.SaveKV(zeroConfClient, FederationPath(clusterId), value); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ApplyClusterFederation writes the federation config stored for the cluster, if any. Returns false when the cluster is not federated.
+func ApplyClusterFederation(zeroConfClient *consul.ConsulClient, clusterId, encryptionKey, path, name string) bool {
+	pair, err := consul.GetKVPair(zeroConfClient, FederationPath(clusterId))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if pair == nil {
+		return false
+	}
+
+	content, err := secret.Decrypt(encryptionKey, string(pair.Value))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	federation := &FederationConfig{}
+	if err := json.Unmarshal([]byte(content), federation); err != nil {
+		log.Fatalf("==> Invalid federation config stored for cluster %s: %s", clusterId, err)
+	}
+
+	agentConfig := &config.AgentConfig{
+		PrimaryDatacenter: federation.PrimaryDatacenter,
+		RetryJoinWan:      federation.WanAddresses,
+	}
+
+	if federation.ReplicationToken != "" {
+		agentConfig.ACL = &config.ACLConfig{
+			EnableTokenReplication: config.Bool(true),
+			Tokens:                 &config.ACLTokensConfig{Replication: federation.ReplicationToken},
+		}
+	}
+
+	log.Printf("==> Saving federation config in %s%s.", path, config.AgentConfigFile(name))
+
+	// The replication token is a credential, so the file is only readable by its owner.
+	if _, err := config.SaveAgentConfig(path, name, agentConfig, 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	return true
+}
+
+// WaitForDatacenters polls the catalog until it lists every expected datacenter.
+func WaitForDatacenters(client *consul.ConsulClient, expected []string, retries, delay int) bool {
+	for count := 1; ; count++ {
+		datacenters, err := consul.GetDatacenters(client)
+		if err != nil {
+			log.Printf("==> Unable to list datacenters: %s", err)
+		}
+
+		known := map[string]bool{}
+		for _, datacenter := range datacenters {
+			known[datacenter] = true
+		}
+
+		var missing []string
+		for _, datacenter := range expected {
+			if !known[datacenter] {
+				missing = append(missing, datacenter)
+			}
+		}
+
+		if len(missing) == 0 {
+			return true
+		}
+
+		if count >= retries {
+			log.Printf("==> Datacenters %v are still missing from the WAN pool.", missing)
+			return false
+		}
+
+		log.Printf("==> Waiting for datacenters %v to join the WAN pool. Try %d of %d.", missing, count, retries)
+		time.Sleep(time.Duration(delay) * time.Second)
+	}
+}
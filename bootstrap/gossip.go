@@ -0,0 +1,306 @@
+package bootstrap
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"redserenity.com/consul-bootstrap/consul"
+	"redserenity.com/consul-bootstrap/secret"
+)
+
+func GossipKeyPath(clusterId string) string {
+	return ClusterPath(clusterId) + "gossip_key"
+}
+
+func ValidateGossipKey(gossipKey string) error {
+	key, err := base64.StdEncoding.DecodeString(gossipKey)
+	if err != nil {
+		return errors.New("gossip key is not valid base64")
+	}
+
+	if len(key) != 32 {
+		return errors.New("gossip key must be 32 bytes")
+	}
+
+	return nil
+}
+
+// SaveGossipKey stores the cluster gossip key on the ZeroConf server, overwriting any previous key.
+func SaveGossipKey(client *consul.ConsulClient, clusterId, gossipKey, encryptionKey string) {
+	log.Printf("==> Saving gossip key for cluster %s to KV store.", clusterId)
+
+	if err := consul.SaveKV(client, GossipKeyPath(clusterId), sealSecret("Gossip key", gossipKey, encryptionKey)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// FetchGossipKey reads the cluster gossip key from the ZeroConf server.
+// If the cluster has no key yet, one is generated and stored so every node of the cluster ends up with the same key.
+func FetchGossipKey(client *consul.ConsulClient, clusterId, encryptionKey string) string {
+	log.Printf("==> Fetching gossip key for cluster %s from ZeroConf Server.", clusterId)
+
+	for {
+		pair, err := consul.GetKVPair(client, GossipKeyPath(clusterId))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if pair != nil && len(pair.Value) > 0 {
+			gossipKey, err := secret.Decrypt(encryptionKey, string(pair.Value))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := ValidateGossipKey(gossipKey); err != nil {
+				log.Fatalf("==> Gossip key stored for cluster %s is invalid: %s", clusterId, err)
+			}
+
+			return gossipKey
+		}
+
+		log.Printf("==> No gossip key stored for cluster %s. Generating a new one.", clusterId)
+
+		gossipKey := GenerateKey()
+		created, err := consul.CreateKV(client, GossipKeyPath(clusterId), sealSecret("Gossip key", gossipKey, encryptionKey))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if created {
+			return gossipKey
+		}
+
+		// Another node stored a key first, read it back instead.
+	}
+}
+
+// VerifyGossipKey checks that the local agent's LAN keyring is using gossipKey as its primary key.
+func VerifyGossipKey(client *consul.ConsulClient, gossipKey string) bool {
+	keyring, err := consul.ListKeyring(client)
+	if err != nil {
+		log.Printf("==> Unable to read the agent keyring (%s). A restart may be required for the gossip config to take effect.", err)
+		return false
+	}
+
+	matches := false
+	for _, ring := range keyring {
+		if ring.WAN {
+			continue
+		}
+
+		installed, isInstalled := ring.Keys[gossipKey]
+		_, isPrimary := ring.PrimaryKeys[gossipKey]
+
+		if !isInstalled {
+			log.Printf("==> Gossip key is not installed in the %s keyring. A restart may be required for the gossip config to take effect.", ring.Datacenter)
+			return false
+		}
+
+		if installed != ring.NumNodes {
+			log.Printf("==> Gossip key is only installed on %d of %d nodes in %s.", installed, ring.NumNodes, ring.Datacenter)
+		}
+
+		if ring.PrimaryKeys != nil && !isPrimary {
+			log.Printf("==> Gossip key is installed but not the primary key in %s.", ring.Datacenter)
+			return false
+		}
+
+		matches = true
+	}
+
+	if matches {
+		log.Printf("==> Agent keyring matches the cluster gossip key.")
+	}
+
+	return matches
+}
+
+// GossipKeyId identifies gossipKey in inventory records without revealing the key.
+func GossipKeyId(gossipKey string) string {
+	sum := sha256.Sum256([]byte(gossipKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// RecordGossipKey notes in the inventory record of nodeName which gossip key its gossip config holds.
+// Nodes without an inventory record are skipped.
+func RecordGossipKey(client *consul.ConsulClient, clusterId, nodeName, gossipKey string) {
+	node := GetNodeRecord(client, clusterId, nodeName)
+	if node == nil || node.GossipKeyId == GossipKeyId(gossipKey) {
+		return
+	}
+
+	node.GossipKeyId = GossipKeyId(gossipKey)
+
+	if err := consul.SaveKVStruct(client, NodeRecordPath(clusterId, nodeName), node); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ReportGossipKeyNodes logs, for every inventory node of the cluster, whether its gossip config holds gossipKey.
+// Returns the nodes that do not have it yet.
+func ReportGossipKeyNodes(client *consul.ConsulClient, clusterId, gossipKey string) []string {
+	var pending []string
+
+	for _, node := range ListNodeRecords(client, clusterId) {
+		if node.GossipKeyId == GossipKeyId(gossipKey) {
+			log.Printf("==>   %-24s gossip config updated", node.Name)
+			continue
+		}
+
+		log.Printf("==>   %-24s pending", node.Name)
+		pending = append(pending, node.Name)
+	}
+
+	return pending
+}
+
+func sealSecret(name, value, encryptionKey string) string {
+	if encryptionKey == "" {
+		log.Printf("==> No -encryption-key provided. %s will be stored unencrypted.", name)
+		return value
+	}
+
+	sealed, err := secret.Encrypt(encryptionKey, value)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return sealed
+}
+
+const (
+	ROTATION_STAGE_INSTALL = "install"
+	ROTATION_STAGE_USE     = "use"
+	ROTATION_STAGE_REMOVE  = "remove"
+)
+
+func GossipRotationPath(clusterId string) string {
+	return ClusterPath(clusterId) + "gossip_rotation"
+}
+
+// StartGossipRotation resumes an interrupted rotation for the cluster, or records a new one using a freshly generated key.
+func StartGossipRotation(zeroConfClient *consul.ConsulClient, clusterId, encryptionKey string) *GossipRotation {
+	pair, err := consul.GetKVPair(zeroConfClient, GossipRotationPath(clusterId))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if pair != nil {
+		rotation := &GossipRotation{}
+		if err := json.Unmarshal(pair.Value, rotation); err != nil {
+			log.Fatal(err)
+		}
+
+		if rotation.OldKey, err = secret.Decrypt(encryptionKey, rotation.OldKey); err != nil {
+			log.Fatal(err)
+		}
+		if rotation.NewKey, err = secret.Decrypt(encryptionKey, rotation.NewKey); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("==> Resuming gossip key rotation started at %s (stage: %s).", rotation.StartedAt.Format(time.RFC3339), rotation.Stage)
+		return rotation
+	}
+
+	rotation := &GossipRotation{
+		Stage:     ROTATION_STAGE_INSTALL,
+		OldKey:    FetchGossipKey(zeroConfClient, clusterId, encryptionKey),
+		NewKey:    GenerateKey(),
+		StartedAt: time.Now().UTC(),
+	}
+
+	SaveGossipRotation(zeroConfClient, clusterId, rotation, encryptionKey)
+	log.Printf("==> Started gossip key rotation for cluster %s.", clusterId)
+
+	return rotation
+}
+
+func SaveGossipRotation(zeroConfClient *consul.ConsulClient, clusterId string, rotation *GossipRotation, encryptionKey string) {
+	sealed := *rotation
+	sealed.OldKey = sealSecret("Gossip key", rotation.OldKey, encryptionKey)
+	sealed.NewKey = sealSecret("Gossip key", rotation.NewKey, encryptionKey)
+
+	if err := consul.SaveKVStruct(zeroConfClient, GossipRotationPath(clusterId), &sealed); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func FinishGossipRotation(zeroConfClient *consul.ConsulClient, clusterId string) {
+	if err := consul.DeleteKV(zeroConfClient, GossipRotationPath(clusterId)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// WaitForGossipKey polls the keyring until every LAN member reports gossipKey as installed.
+func WaitForGossipKey(client *consul.ConsulClient, gossipKey string, retries, delay int) {
+	for count := 1; ; count++ {
+		keyring, err := consul.ListKeyring(client)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		complete := true
+		for _, ring := range keyring {
+			if ring.WAN {
+				continue
+			}
+
+			for node, message := range ring.Messages {
+				log.Printf("==>   %s: %s", node, message)
+			}
+
+			installed := ring.Keys[gossipKey]
+			log.Printf("==> %s: new gossip key installed on %d of %d nodes.", ring.Datacenter, installed, ring.NumNodes)
+
+			if installed < ring.NumNodes {
+				complete = false
+			}
+		}
+
+		if complete {
+			return
+		}
+
+		if count >= retries {
+			ReportMissingGossipKey(client)
+			log.Fatalf("==> Gossip key was not installed on every node after %d checks. Re-run to resume the rotation.", retries)
+		}
+
+		time.Sleep(time.Duration(delay) * time.Second)
+	}
+}
+
+// ReportMissingGossipKey lists members that are not alive, as they will never report the new key.
+func ReportMissingGossipKey(client *consul.ConsulClient) {
+	members, err := consul.ListMembers(client)
+	if err != nil {
+		log.Printf("==> Unable to list cluster members: %s", err)
+		return
+	}
+
+	for _, member := range members {
+		if member.Status != 1 {
+			log.Printf("==>   %s (%s) is not alive and may be blocking the rotation.", member.Name, member.Addr)
+		}
+	}
+}
+
+func GossipKeyInstalled(client *consul.ConsulClient, gossipKey string) bool {
+	keyring, err := consul.ListKeyring(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, ring := range keyring {
+		if !ring.WAN && ring.Keys[gossipKey] > 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,124 @@
+package bootstrap
+
+import (
+	"reflect"
+	"testing"
+
+	"redserenity.com/consul-bootstrap/consul"
+	"redserenity.com/consul-bootstrap/secret"
+)
+
+func TestStartGossipRotation(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	oldKey := GenerateKey()
+	SaveGossipKey(client, "web", oldKey, "passphrase")
+
+	rotation := StartGossipRotation(client, "web", "passphrase")
+	if rotation.Stage != ROTATION_STAGE_INSTALL || rotation.OldKey != oldKey {
+		t.Fatalf("rotation is %+v", rotation)
+	}
+	if err := ValidateGossipKey(rotation.NewKey); err != nil || rotation.NewKey == oldKey {
+		t.Fatalf("new key %q is not a fresh gossip key (%v)", rotation.NewKey, err)
+	}
+
+	stored := &GossipRotation{}
+	fake.get("clusters/web/gossip_rotation", stored)
+	if !secret.IsEncrypted(stored.OldKey) || !secret.IsEncrypted(stored.NewKey) {
+		t.Fatalf("rotation keys are stored as %q and %q", stored.OldKey, stored.NewKey)
+	}
+
+	// An interrupted rotation resumes with the same keys at the stage it reached.
+	rotation.Stage = ROTATION_STAGE_USE
+	SaveGossipRotation(client, "web", rotation, "passphrase")
+
+	resumed := StartGossipRotation(client, "web", "passphrase")
+	if resumed.Stage != ROTATION_STAGE_USE || resumed.OldKey != oldKey || resumed.NewKey != rotation.NewKey {
+		t.Fatalf("resumed rotation is %+v, want %+v", resumed, rotation)
+	}
+
+	FinishGossipRotation(client, "web")
+	if keys := fake.keys(); !reflect.DeepEqual(keys, []string{"clusters/web/gossip_key"}) {
+		t.Fatalf("stored keys after the rotation are %v", keys)
+	}
+}
+
+func TestGossipKeyringRotation(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	oldKey, newKey := GenerateKey(), GenerateKey()
+	fake.members = 3
+	fake.keyring[oldKey] = 3
+	fake.primaryKey = oldKey
+
+	if !VerifyGossipKey(client, oldKey) {
+		t.Fatal("old key is not verified as the primary key")
+	}
+
+	if err := consul.InstallKeyringKey(client, newKey); err != nil {
+		t.Fatal(err)
+	}
+	WaitForGossipKey(client, newKey, 1, 0)
+
+	if !GossipKeyInstalled(client, newKey) {
+		t.Fatal("new key is not reported as installed")
+	}
+	if VerifyGossipKey(client, newKey) {
+		t.Fatal("new key is verified before it is the primary key")
+	}
+
+	if err := consul.UseKeyringKey(client, newKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := consul.RemoveKeyringKey(client, oldKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if !VerifyGossipKey(client, newKey) {
+		t.Fatal("new key is not verified as the primary key")
+	}
+	if GossipKeyInstalled(client, oldKey) {
+		t.Fatal("old key is still reported as installed")
+	}
+}
+
+func TestVerifyGossipKeyPartiallyInstalled(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	gossipKey := GenerateKey()
+	fake.members = 3
+	fake.keyring[gossipKey] = 2
+	fake.primaryKey = gossipKey
+
+	// A member missing the key is reported, the key is still the one the agent uses.
+	if !VerifyGossipKey(client, gossipKey) {
+		t.Fatal("primary key is not verified")
+	}
+	if VerifyGossipKey(client, GenerateKey()) {
+		t.Fatal("a key that is not installed is verified")
+	}
+}
+
+func TestReportGossipKeyNodes(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	gossipKey := GenerateKey()
+	for _, node := range []*ClusterNode{{Name: "node1", ClusterId: "web"}, {Name: "node2", ClusterId: "web"}, {Name: "node3", ClusterId: "db"}} {
+		SaveNodeRecord(client, node)
+	}
+
+	RecordGossipKey(client, "web", "node1", gossipKey)
+	RecordGossipKey(client, "web", "missing", gossipKey)
+
+	if node := GetNodeRecord(client, "web", "node1"); node.GossipKeyId != GossipKeyId(gossipKey) {
+		t.Fatalf("node1 records gossip key %q", node.GossipKeyId)
+	}
+
+	if pending := ReportGossipKeyNodes(client, "web", gossipKey); !reflect.DeepEqual(pending, []string{"node2"}) {
+		t.Fatalf("pending nodes are %v, want [node2]", pending)
+	}
+}
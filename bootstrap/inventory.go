@@ -0,0 +1,175 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+func NodePath(clusterId, nodeName string) string {
+	return ClusterPath(clusterId) + "nodes/" + nodeName + "/"
+}
+
+func NodeRecordPath(clusterId, nodeName string) string {
+	return NodePath(clusterId, nodeName) + "record"
+}
+
+// SaveNodeToken stores the node's ACL token for the ZeroConf Server. The registration tokens of the cluster can read it,
+// so it is only stored sealed with encryptionKey.
+func SaveNodeToken(client *consul.ConsulClient, clusterId, nodeName, token, encryptionKey string) {
+	if encryptionKey == "" {
+		log.Printf("==> No -encryption-key provided. The ACL token of %s is not stored on the ZeroConf Server.", nodeName)
+		return
+	}
+
+	if err := consul.SaveKV(client, NodePath(clusterId, nodeName)+"token", sealSecret("Node token", token, encryptionKey)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// GetNodeRecord returns nil when the node has no inventory record in the cluster.
+func GetNodeRecord(client *consul.ConsulClient, clusterId, nodeName string) *ClusterNode {
+	pair, err := consul.GetKVPair(client, NodeRecordPath(clusterId, nodeName))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if pair == nil {
+		return nil
+	}
+
+	node := &ClusterNode{}
+	if err := json.Unmarshal(pair.Value, node); err != nil {
+		log.Fatalf("==> Invalid inventory record for %s: %s", nodeName, err)
+	}
+
+	return node
+}
+
+// SaveNodeRecord stores node in the inventory, keeping the registration time, token accessor and gossip key ID of an earlier record.
+func SaveNodeRecord(client *consul.ConsulClient, node *ClusterNode) {
+	now := time.Now().UTC()
+
+	if existing := GetNodeRecord(client, node.ClusterId, node.Name); existing != nil {
+		node.RegisteredAt = existing.RegisteredAt
+		if node.TokenAccessor == "" {
+			node.TokenAccessor = existing.TokenAccessor
+		}
+		if node.GossipKeyId == "" {
+			node.GossipKeyId = existing.GossipKeyId
+		}
+	}
+
+	if node.RegisteredAt.IsZero() {
+		node.RegisteredAt = now
+	}
+	node.SanitizedName = SanitizeNodeName(node.Name)
+	node.LastSeen = now
+
+	log.Printf("==> Saving inventory record for %s.", node.Name)
+
+	if err := consul.SaveKVStruct(client, NodeRecordPath(node.ClusterId, node.Name), node); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// TouchNodeRecord updates the last seen time of an existing inventory record.
+func TouchNodeRecord(client *consul.ConsulClient, clusterId, nodeName string) {
+	node := GetNodeRecord(client, clusterId, nodeName)
+	if node == nil {
+		return
+	}
+
+	node.LastSeen = time.Now().UTC()
+
+	if err := consul.SaveKVStruct(client, NodeRecordPath(clusterId, nodeName), node); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ListNodeRecords returns the inventory records of the cluster, or of every cluster when clusterId is "".
+func ListNodeRecords(client *consul.ConsulClient, clusterId string) []*ClusterNode {
+	prefix := "clusters/"
+	if clusterId != "" {
+		prefix = ClusterPath(clusterId)
+	}
+
+	pairs, err := consul.ListKV(client, prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var nodes []*ClusterNode
+	for _, pair := range pairs {
+		// clusters/<id>/nodes/<name>/record
+		parts := strings.Split(pair.Key, "/")
+		if len(parts) != 5 || parts[2] != "nodes" || parts[4] != "record" {
+			continue
+		}
+
+		node := &ClusterNode{}
+		if err := json.Unmarshal(pair.Value, node); err != nil {
+			log.Printf("==> Skipping invalid inventory record %s: %s", pair.Key, err)
+			continue
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].ClusterId != nodes[j].ClusterId {
+			return nodes[i].ClusterId < nodes[j].ClusterId
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	return nodes
+}
+
+func WriteJson(out io.Writer, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, string(content))
+	return err
+}
+
+func WriteNodesTable(out io.Writer, nodes []*ClusterNode) error {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(table, "NAME\tCLUSTER\tROLE\tDATACENTER\tADDRESS\tVERSION\tREGISTERED\tLAST SEEN")
+	for _, node := range nodes {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			node.Name, node.ClusterId, node.Role, node.Datacenter, node.Address, node.ConsulVersion,
+			node.RegisteredAt.Format(time.RFC3339), node.LastSeen.Format(time.RFC3339))
+	}
+
+	return table.Flush()
+}
+
+func WriteNodeDetails(out io.Writer, node *ClusterNode) error {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(table, "Name:\t%s\n", node.Name)
+	fmt.Fprintf(table, "Sanitized Name:\t%s\n", node.SanitizedName)
+	fmt.Fprintf(table, "Cluster:\t%s\n", node.ClusterId)
+	fmt.Fprintf(table, "Role:\t%s\n", node.Role)
+	fmt.Fprintf(table, "Datacenter:\t%s\n", node.Datacenter)
+	fmt.Fprintf(table, "Address:\t%s\n", node.Address)
+	fmt.Fprintf(table, "Consul Version:\t%s\n", node.ConsulVersion)
+	fmt.Fprintf(table, "Registered At:\t%s\n", node.RegisteredAt.Format(time.RFC3339))
+	fmt.Fprintf(table, "Last Seen:\t%s\n", node.LastSeen.Format(time.RFC3339))
+	fmt.Fprintf(table, "Token Accessor:\t%s\n", node.TokenAccessor)
+	fmt.Fprintf(table, "Gossip Key ID:\t%s\n", node.GossipKeyId)
+
+	return table.Flush()
+}
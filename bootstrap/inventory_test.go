@@ -0,0 +1,106 @@
+package bootstrap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"redserenity.com/consul-bootstrap/secret"
+)
+
+func TestNodeRecordsPerCluster(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	SaveNodeRecord(client, &ClusterNode{Name: "node1", ClusterId: "web", MachineId: "machine-a"})
+	SaveNodeRecord(client, &ClusterNode{Name: "node1", ClusterId: "db", MachineId: "machine-b"})
+
+	if node := GetNodeRecord(client, "web", "node1"); node == nil || node.MachineId != "machine-a" {
+		t.Fatalf("web record is %+v", node)
+	}
+	if node := GetNodeRecord(client, "db", "node1"); node == nil || node.MachineId != "machine-b" {
+		t.Fatalf("db record is %+v", node)
+	}
+
+	if err := CheckNodeClaim(client, "db", "node1", "machine-b", ""); err != nil {
+		t.Fatalf("same name in another cluster is refused: %s", err)
+	}
+	if err := CheckNodeClaim(client, "mail", "node1", "machine-c", ""); err != nil {
+		t.Fatalf("name unused in the cluster is refused: %s", err)
+	}
+	if err := CheckNodeClaim(client, "web", "node1", "machine-c", ""); err == nil {
+		t.Fatal("name claimed in the cluster by another machine is accepted")
+	}
+}
+
+func TestSaveNodeToken(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	SaveNodeToken(client, "web", "node1", "node-secret", "")
+	if keys := fake.keys(); len(keys) != 0 {
+		t.Fatalf("token stored without a key: %v", keys)
+	}
+
+	SaveNodeToken(client, "web", "node1", "node-secret", "passphrase")
+
+	fake.mu.Lock()
+	stored := string(fake.kv["clusters/web/nodes/node1/token"].Value)
+	fake.mu.Unlock()
+
+	if plaintext, err := secret.Decrypt("passphrase", stored); err != nil || plaintext != "node-secret" {
+		t.Fatalf("stored token %q does not decrypt: %v", stored, err)
+	}
+}
+
+func TestListNodeRecords(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	fake.put("clusters/web/nodes/node2/record", &ClusterNode{Name: "node2", ClusterId: "web"})
+	fake.put("clusters/web/nodes/node1/record", &ClusterNode{Name: "node1", ClusterId: "web"})
+	fake.put("clusters/db/nodes/node3/record", &ClusterNode{Name: "node3", ClusterId: "db"})
+	fake.put("clusters/db/nodes/broken/record", []byte("{"))
+	fake.put("clusters/web/nodes/node1/token", []byte("sealed"))
+	fake.put("clusters/web/servers", &ClusterServers{Expect: 1})
+
+	names := func(nodes []*ClusterNode) string {
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.ClusterId+"/"+node.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := names(ListNodeRecords(client, "")); got != "db/node3,web/node1,web/node2" {
+		t.Fatalf("all clusters list %s", got)
+	}
+	if got := names(ListNodeRecords(client, "web")); got != "web/node1,web/node2" {
+		t.Fatalf("cluster web lists %s", got)
+	}
+	if got := ListNodeRecords(client, "mail"); len(got) != 0 {
+		t.Fatalf("unknown cluster lists %s", names(got))
+	}
+}
+
+func TestWriteNodesTable(t *testing.T) {
+	seen := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	nodes := []*ClusterNode{{
+		Name: "node1", ClusterId: "web", Role: ROLE_SERVER, Datacenter: "dc1", Address: "10.0.0.1",
+		ConsulVersion: "1.9.0", RegisteredAt: seen, LastSeen: seen,
+	}}
+
+	out := &bytes.Buffer{}
+	if err := WriteNodesTable(out, nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "NAME") {
+		t.Fatalf("table is\n%s", out)
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "node1 web server dc1 10.0.0.1 1.9.0 2024-02-01T10:00:00Z 2024-02-01T10:00:00Z" {
+		t.Fatalf("row is %q", lines[1])
+	}
+}
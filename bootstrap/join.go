@@ -0,0 +1,68 @@
+package bootstrap
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+const CLUSTER_SERVICE = "consul-cluster"
+
+func ClusterTag(clusterId string) string {
+	return "cluster=" + clusterId
+}
+
+// DiscoverJoinAddresses returns the join addresses of the healthy cluster members registered on the ZeroConf server, excluding nodeName.
+func DiscoverJoinAddresses(zeroConfClient *consul.ConsulClient, clusterId, nodeName string) []string {
+	entries, err := consul.GetHealthyServices(zeroConfClient, CLUSTER_SERVICE, ClusterTag(clusterId))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var addresses []string
+	for _, entry := range entries {
+		if entry.Service.ID == SanitizeNodeName(nodeName) {
+			continue
+		}
+
+		if entry.Service.Address == "" {
+			log.Printf("==> Cluster member %s registered without an address. Skipping.", entry.Service.ID)
+			continue
+		}
+
+		addresses = append(addresses, JoinAddress(entry.Service))
+	}
+
+	sort.Strings(addresses)
+	return addresses
+}
+
+// JoinAddress returns the retry_join address of a cluster member, its address with the Serf LAN port from the
+// registration meta. Members registered without the port meta are joined on the default port.
+func JoinAddress(service *consulApi.AgentService) string {
+	port, err := strconv.Atoi(service.Meta["serf_lan_port"])
+	if err != nil || port <= 0 {
+		return service.Address
+	}
+
+	return net.JoinHostPort(service.Address, strconv.Itoa(port))
+}
+
+// SaveJoinConfig writes the retry_join list. Returns false when the file already had the same content.
+func SaveJoinConfig(addresses []string, path, name string) bool {
+	changed, err := config.SaveAgentConfig(path, name, &config.AgentConfig{RetryJoin: addresses}, config.DefaultMode())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if changed {
+		log.Printf("==> Saved %d retry_join addresses in %s%s.", len(addresses), path, config.AgentConfigFile(name))
+	}
+
+	return changed
+}
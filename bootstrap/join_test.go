@@ -0,0 +1,30 @@
+package bootstrap
+
+import (
+	"testing"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+func TestJoinAddress(t *testing.T) {
+	cases := []struct {
+		name    string
+		address string
+		meta    map[string]string
+		want    string
+	}{
+		{"default port", "10.0.0.1", map[string]string{"serf_lan_port": "8301"}, "10.0.0.1:8301"},
+		{"custom port", "10.0.0.1", map[string]string{"serf_lan_port": "9301"}, "10.0.0.1:9301"},
+		{"ipv6", "fd00::1", map[string]string{"serf_lan_port": "9301"}, "[fd00::1]:9301"},
+		{"no meta", "10.0.0.1", nil, "10.0.0.1"},
+		{"invalid port", "10.0.0.1", map[string]string{"serf_lan_port": "serf"}, "10.0.0.1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := JoinAddress(&consulApi.AgentService{Address: c.address, Meta: c.meta}); got != c.want {
+				t.Fatalf("JoinAddress = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package bootstrap
+
+import (
+	"log"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/consul"
+	"redserenity.com/consul-bootstrap/secret"
+)
+
+func ClusterLockPath(clusterId string) string {
+	return ClusterBootstrapPath(clusterId) + "lock"
+}
+
+// LockClusterBootstrap blocks until this node holds the bootstrap lock of the cluster on the ZeroConf server, waiting
+// at most timeout. The lock is tied to a session, so it is released if the node dies while holding it.
+func LockClusterBootstrap(zeroConfClient *consul.ConsulClient, clusterId, nodeName string, timeout time.Duration) *consulApi.Lock {
+	holder, err := consul.GetKVPair(zeroConfClient, ClusterLockPath(clusterId))
+	if err == nil && holder != nil && holder.Session != "" {
+		log.Printf("==> Waiting up to %s for %s to finish bootstrapping cluster %s.", timeout, holder.Value, clusterId)
+	}
+
+	lock, err := consul.AcquireLock(zeroConfClient, ClusterLockPath(clusterId), nodeName, "zeroconf-bootstrap-"+clusterId, timeout)
+	if err != nil {
+		log.Fatalf("==> Unable to acquire the bootstrap lock of cluster %s (%s). Raise -connect-retries or -connect-delay if another node is still bootstrapping it.", clusterId, err)
+	}
+
+	log.Printf("==> Acquired bootstrap lock for cluster %s.", clusterId)
+
+	return lock
+}
+
+func UnlockClusterBootstrap(lock *consulApi.Lock, clusterId string) {
+	if err := consul.ReleaseLock(lock); err != nil {
+		log.Printf("==> Unable to release bootstrap lock for cluster %s (%s). It is released once its session expires.", clusterId, err)
+	}
+}
+
+func ClusterBootstrapPath(clusterId string) string {
+	return ClusterPath(clusterId) + "bootstrap/"
+}
+
+// SaveClusterBootstrapToken records that the cluster was bootstrapped and stores its bootstrap token for the other
+// servers of the cluster. The token is a management token the registration tokens of the cluster can read, so it is
+// only stored sealed with encryptionKey.
+func SaveClusterBootstrapToken(zeroConfClient *consul.ConsulClient, clusterId string, token *consulApi.ACLToken, encryptionKey string) {
+	record := *token
+	record.SecretID = ""
+
+	if err := consul.SaveKVStruct(zeroConfClient, ClusterBootstrapPath(clusterId)+"complete", &record); err != nil {
+		log.Fatal(err)
+	}
+
+	if encryptionKey == "" {
+		log.Printf("==> No -encryption-key provided. The bootstrap token of cluster %s is not stored on the ZeroConf Server, pass it as -bootstrap-token to the other servers.", clusterId)
+		return
+	}
+
+	log.Printf("==> Saving sealed bootstrap token for cluster %s to KV store.", clusterId)
+
+	if err := consul.SaveKV(zeroConfClient, ClusterBootstrapPath(clusterId)+"token", sealSecret("Bootstrap token", token.SecretID, encryptionKey)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// IsClusterBootstrapped reports whether a node recorded the bootstrap of the cluster.
+func IsClusterBootstrapped(zeroConfClient *consul.ConsulClient, clusterId string) bool {
+	pair, err := consul.GetKVPair(zeroConfClient, ClusterBootstrapPath(clusterId)+"complete")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return pair != nil
+}
+
+// GetClusterBootstrapToken returns the bootstrap token a previous node stored for the cluster, or "" if none is stored.
+func GetClusterBootstrapToken(zeroConfClient *consul.ConsulClient, clusterId, encryptionKey string) string {
+	pair, err := consul.GetKVPair(zeroConfClient, ClusterBootstrapPath(clusterId)+"token")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if pair == nil || len(pair.Value) == 0 {
+		return ""
+	}
+
+	token, err := secret.Decrypt(encryptionKey, string(pair.Value))
+	if err != nil {
+		log.Fatalf("==> Unable to read the bootstrap token of cluster %s: %s", clusterId, err)
+	}
+
+	return token
+}
@@ -0,0 +1,89 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/secret"
+)
+
+func TestClusterBootstrapTokenHandoff(t *testing.T) {
+	fake := newFakeConsul(t)
+	first, second := fake.client(), fake.client()
+
+	lock := LockClusterBootstrap(first, "web", "server1", 5*time.Second)
+
+	acquired := make(chan string)
+	go func() {
+		lock := LockClusterBootstrap(second, "web", "server2", 5*time.Second)
+		defer UnlockClusterBootstrap(lock, "web")
+		acquired <- GetClusterBootstrapToken(second, "web", "passphrase")
+	}()
+
+	SaveClusterBootstrapToken(first, "web", &consulApi.ACLToken{AccessorID: "accessor", SecretID: "bootstrap-secret"}, "passphrase")
+
+	select {
+	case <-acquired:
+		t.Fatal("second server acquired the lock while the first held it")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	UnlockClusterBootstrap(lock, "web")
+
+	select {
+	case token := <-acquired:
+		if token != "bootstrap-secret" {
+			t.Fatalf("second server read token %q", token)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second server did not acquire the lock after it was released")
+	}
+
+	if !IsClusterBootstrapped(second, "web") {
+		t.Fatal("cluster is not recorded as bootstrapped")
+	}
+}
+
+func TestSaveClusterBootstrapTokenSealed(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	SaveClusterBootstrapToken(client, "web", &consulApi.ACLToken{AccessorID: "accessor", SecretID: "bootstrap-secret"}, "passphrase")
+
+	fake.mu.Lock()
+	stored := string(fake.kv["clusters/web/bootstrap/token"].Value)
+	complete := fake.kv["clusters/web/bootstrap/complete"].Value
+	fake.mu.Unlock()
+
+	if !secret.IsEncrypted(stored) {
+		t.Fatalf("bootstrap token is stored as %q", stored)
+	}
+
+	record := &consulApi.ACLToken{}
+	if err := json.Unmarshal(complete, record); err != nil {
+		t.Fatal(err)
+	}
+	if record.AccessorID != "accessor" || record.SecretID != "" {
+		t.Fatalf("complete record is %+v", record)
+	}
+}
+
+func TestSaveClusterBootstrapTokenWithoutKey(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	SaveClusterBootstrapToken(client, "web", &consulApi.ACLToken{AccessorID: "accessor", SecretID: "bootstrap-secret"}, "")
+
+	if keys := fake.keys(); len(keys) != 1 || keys[0] != "clusters/web/bootstrap/complete" {
+		t.Fatalf("stored keys are %v", keys)
+	}
+
+	if token := GetClusterBootstrapToken(client, "web", ""); token != "" {
+		t.Fatalf("read token %q", token)
+	}
+	if !IsClusterBootstrapped(client, "web") {
+		t.Fatal("cluster is not recorded as bootstrapped")
+	}
+}
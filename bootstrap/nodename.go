@@ -0,0 +1,179 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+const MAX_NODE_NAME_LENGTH = 63
+
+var (
+	nodeNamePattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+	policyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+)
+
+// ValidateNodeName accepts hostnames: letters, digits, "-", "_" and ".", starting and ending with a letter or digit.
+// Consul only resolves names made of letters, digits and "-" through DNS, other names are accepted with a warning.
+func ValidateNodeName(nodeName string) error {
+	if nodeName == "" {
+		return errors.New("node name must not be empty")
+	}
+
+	if len(nodeName) > MAX_NODE_NAME_LENGTH {
+		return fmt.Errorf("node name must not be longer than %d characters", MAX_NODE_NAME_LENGTH)
+	}
+
+	if !nodeNamePattern.MatchString(nodeName) {
+		return errors.New("node name may only contain letters, digits, \"-\", \"_\" and \".\", and must start and end with a letter or digit")
+	}
+
+	return nil
+}
+
+func ValidatePolicyName(policyName string) error {
+	if !policyNamePattern.MatchString(policyName) {
+		return fmt.Errorf("policy name %q must be 1 to 128 letters, digits, \"-\" or \"_\"", policyName)
+	}
+
+	return nil
+}
+
+// SanitizeNodeName encodes a node name for use in policy names and service IDs.
+// Letters, digits and "-" are kept, every other byte (including "_") becomes "_" followed by its hex value,
+// so "web.1" and "web_1" encode to "web_2e1" and "web_5f1" and never collide.
+func SanitizeNodeName(nodeName string) string {
+	var encoded strings.Builder
+
+	for i := 0; i < len(nodeName); i++ {
+		c := nodeName[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "_%02x", c)
+		}
+	}
+
+	return encoded.String()
+}
+
+func NodePolicyName(nodePrefix, nodeName string) string {
+	return nodePrefix + SanitizeNodeName(nodeName)
+}
+
+func NodePolicyDescription(nodeName string) string {
+	return "Agent Policy for node " + nodeName
+}
+
+// LegacyNodeName is how node names were encoded before SanitizeNodeName escaped every byte: only "." was replaced
+// with "_". Node policies, registration policies and service IDs created back then still use it.
+func LegacyNodeName(nodeName string) string {
+	return strings.Replace(nodeName, ".", "_", -1)
+}
+
+// MigrateNodePolicy renames the node policy of nodeName if it was created under the legacy encoding.
+func MigrateNodePolicy(client *consul.ConsulClient, nodePrefix, nodeName string) error {
+	return migrateLegacyPolicy(client, nodePrefix+LegacyNodeName(nodeName), NodePolicyName(nodePrefix, nodeName), NodePolicyDescription(nodeName))
+}
+
+// migrateLegacyPolicy renames a policy created under a legacy name. Tokens link policies by ID, so they keep working.
+// The legacy names of "web.1" and "web_1" collide, so only a policy whose description names the node is renamed.
+func migrateLegacyPolicy(client *consul.ConsulClient, legacyName, policyName, description string) error {
+	if legacyName == policyName || consul.PolicyExistsByName(client, policyName) {
+		return nil
+	}
+
+	policy, err := consul.GetPolicyByName(client, legacyName)
+	if err != nil || policy == nil || policy.Description != description {
+		return nil
+	}
+
+	log.Printf("==> Renaming policy %s to %s.", legacyName, policyName)
+
+	policy.Name = policyName
+	_, err = consul.UpdatePolicy(client, policy)
+	return err
+}
+
+// NodeServiceIds returns the IDs nodeName is registered under on the ZeroConf server: the current one and, for nodes
+// registered before the current encoding, the legacy one. A legacy registration only counts if it has the node's
+// address, since "web.1" and "web_1" share a legacy ID. An empty address matches any registration.
+func NodeServiceIds(zeroConfClient *consul.ConsulClient, nodeName, address string) ([]string, error) {
+	serviceId, legacyId := SanitizeNodeName(nodeName), LegacyNodeName(nodeName)
+
+	var ids []string
+	for _, id := range []string{serviceId, legacyId} {
+		if id == legacyId && legacyId == serviceId {
+			continue
+		}
+
+		service, err := consul.GetServiceInstance(zeroConfClient, CLUSTER_SERVICE, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if service == nil || (id == legacyId && address != "" && service.ServiceAddress != address) {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// LegacyServiceId returns the legacy service ID nodeName is still registered under, or "" if there is none.
+func LegacyServiceId(zeroConfClient *consul.ConsulClient, nodeName, address string) string {
+	ids, err := NodeServiceIds(zeroConfClient, nodeName, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, id := range ids {
+		if id != SanitizeNodeName(nodeName) {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// LocalMachineId returns the systemd/dbus machine ID of this host, or "" if it has none.
+func LocalMachineId() string {
+	for _, file := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if content, err := ioutil.ReadFile(file); err == nil {
+			if id := strings.TrimSpace(string(content)); id != "" {
+				return id
+			}
+		}
+	}
+
+	return ""
+}
+
+// CheckNodeClaim returns an error when nodeName is already registered in the cluster by a different host.
+// Hosts are compared by machine ID when both sides have one, otherwise by address.
+func CheckNodeClaim(zeroConfClient *consul.ConsulClient, clusterId, nodeName, machineId, address string) error {
+	node := GetNodeRecord(zeroConfClient, clusterId, nodeName)
+	if node == nil {
+		return nil
+	}
+
+	if node.MachineId != "" && machineId != "" {
+		if node.MachineId != machineId {
+			return fmt.Errorf("node name %s is already claimed by machine %s (%s)", nodeName, node.MachineId, node.Address)
+		}
+		return nil
+	}
+
+	if node.Address != "" && address != "" && node.Address != address {
+		return fmt.Errorf("node name %s is already claimed by %s", nodeName, node.Address)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package bootstrap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeNodeName(t *testing.T) {
+	cases := []struct {
+		name   string
+		want   string
+		legacy string
+	}{
+		{"web1", "web1", "web1"},
+		{"Web-1", "Web-1", "Web-1"},
+		{"web.1", "web_2e1", "web_1"},
+		{"web_1", "web_5f1", "web_1"},
+		{"db.eu.example.com", "db_2eeu_2eexample_2ecom", "db_eu_example_com"},
+		{"a_2e1", "a_5f2e1", "a_2e1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := SanitizeNodeName(c.name); got != c.want {
+				t.Errorf("SanitizeNodeName = %q, want %q", got, c.want)
+			}
+
+			if got := LegacyNodeName(c.name); got != c.legacy {
+				t.Errorf("LegacyNodeName = %q, want %q", got, c.legacy)
+			}
+
+			if err := ValidatePolicyName(NodePolicyName("Node-", c.name)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSanitizeNodeNameDoesNotCollide(t *testing.T) {
+	names := []string{"web.1", "web_1", "web-1", "web1", "web_2e1", "web.2e1", "a.b_c", "a_b.c"}
+	seen := map[string]string{}
+
+	for _, name := range names {
+		encoded := SanitizeNodeName(name)
+		if other, exists := seen[encoded]; exists {
+			t.Errorf("%q and %q both encode to %q", other, name, encoded)
+		}
+		seen[encoded] = name
+	}
+}
+
+func TestValidateNodeName(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"web1", true},
+		{"web-1.example.com", true},
+		{"web_1", true},
+		{"1", true},
+		{strings.Repeat("a", MAX_NODE_NAME_LENGTH), true},
+		{"", false},
+		{strings.Repeat("a", MAX_NODE_NAME_LENGTH+1), false},
+		{"-web", false},
+		{"web.", false},
+		{"web 1", false},
+		{"web/1", false},
+		{"wéb", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := ValidateNodeName(c.name); (err == nil) != c.valid {
+				t.Fatalf("ValidateNodeName(%q) = %v, want valid %t", c.name, err, c.valid)
+			}
+		})
+	}
+}
+
+func TestValidatePolicyName(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"Node-web_2e1", true},
+		{strings.Repeat("a", 128), true},
+		{"", false},
+		{strings.Repeat("a", 129), false},
+		{"Node-web.1", false},
+		{"Node web", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := ValidatePolicyName(c.name); (err == nil) != c.valid {
+				t.Fatalf("ValidatePolicyName(%q) = %v, want valid %t", c.name, err, c.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"text/tabwriter"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+func LoadOperatorSettings(file string) *OperatorSettings {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	settings := &OperatorSettings{}
+	if err := json.Unmarshal(content, settings); err != nil {
+		log.Fatalf("==> Invalid operator config %s: %s", file, err)
+	}
+
+	if settings.Autopilot != nil {
+		for name, value := range map[string]*string{
+			"last_contact_threshold":    settings.Autopilot.LastContactThreshold,
+			"server_stabilization_time": settings.Autopilot.ServerStabilizationTime,
+		} {
+			if value == nil {
+				continue
+			}
+			if _, err := time.ParseDuration(*value); err != nil {
+				log.Fatalf("==> Invalid autopilot %s in %s: %s", name, file, err)
+			}
+		}
+	}
+
+	if settings.Raft != nil && settings.Raft.RaftMultiplier != nil && (*settings.Raft.RaftMultiplier < 1 || *settings.Raft.RaftMultiplier > 10) {
+		log.Fatalf("==> raft_multiplier in %s must be between 1 and 10", file)
+	}
+
+	return settings
+}
+
+// DesiredAutopilotConfig returns current with the values of settings applied.
+func DesiredAutopilotConfig(current *consulApi.AutopilotConfiguration, settings *AutopilotSettings) *consulApi.AutopilotConfiguration {
+	desired := *current
+	if settings == nil {
+		return &desired
+	}
+
+	if settings.CleanupDeadServers != nil {
+		desired.CleanupDeadServers = *settings.CleanupDeadServers
+	}
+
+	if settings.LastContactThreshold != nil {
+		duration, _ := time.ParseDuration(*settings.LastContactThreshold)
+		desired.LastContactThreshold = consulApi.NewReadableDuration(duration)
+	}
+
+	if settings.MaxTrailingLogs != nil {
+		desired.MaxTrailingLogs = *settings.MaxTrailingLogs
+	}
+
+	if settings.MinQuorum != nil {
+		desired.MinQuorum = *settings.MinQuorum
+	}
+
+	if settings.ServerStabilizationTime != nil {
+		duration, _ := time.ParseDuration(*settings.ServerStabilizationTime)
+		desired.ServerStabilizationTime = consulApi.NewReadableDuration(duration)
+	}
+
+	return &desired
+}
+
+// ApplyAutopilotSettings updates the cluster autopilot configuration through the Operator API.
+func ApplyAutopilotSettings(client *consul.ConsulClient, settings *AutopilotSettings) {
+	if settings == nil {
+		return
+	}
+
+	log.Printf("==> Applying autopilot settings.")
+
+	for {
+		current, err := consul.GetAutopilotConfig(client)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		saved, err := consul.SetAutopilotConfig(client, DesiredAutopilotConfig(current, settings))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if saved {
+			return
+		}
+	}
+}
+
+// SaveRaftSettings writes the raft tuning that can only be set through agent config. It takes effect after a restart.
+func SaveRaftSettings(settings *RaftSettings, path, name string) {
+	if settings == nil || settings.RaftMultiplier == nil {
+		return
+	}
+
+	log.Printf("==> Saving raft settings in %s%s.", path, config.AgentConfigFile(name))
+
+	agentConfig := &config.AgentConfig{Performance: &config.PerformanceConfig{RaftMultiplier: *settings.RaftMultiplier}}
+	if _, err := config.SaveAgentConfig(path, name, agentConfig, config.DefaultMode()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// WriteAutopilotComparison renders current vs desired autopilot values. Returns false when they differ.
+func WriteAutopilotComparison(out io.Writer, current, desired *consulApi.AutopilotConfiguration) bool {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	matches := true
+
+	row := func(name string, currentValue, desiredValue interface{}) {
+		state := "ok"
+		if fmt.Sprint(currentValue) != fmt.Sprint(desiredValue) {
+			state = "differs"
+			matches = false
+		}
+		fmt.Fprintf(table, "%s\t%v\t%v\t%s\n", name, currentValue, desiredValue, state)
+	}
+
+	fmt.Fprintln(table, "SETTING\tCURRENT\tDESIRED\tSTATE")
+	row("cleanup_dead_servers", current.CleanupDeadServers, desired.CleanupDeadServers)
+	row("last_contact_threshold", current.LastContactThreshold, desired.LastContactThreshold)
+	row("max_trailing_logs", current.MaxTrailingLogs, desired.MaxTrailingLogs)
+	row("min_quorum", current.MinQuorum, desired.MinQuorum)
+	row("server_stabilization_time", current.ServerStabilizationTime, desired.ServerStabilizationTime)
+	table.Flush()
+
+	return matches
+}
+
+func WriteAutopilotHealth(out io.Writer, health *consulApi.OperatorHealthReply) {
+	fmt.Fprintf(out, "\nHealthy: %t, Failure Tolerance: %d\n\n", health.Healthy, health.FailureTolerance)
+
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "SERVER\tADDRESS\tLEADER\tVOTER\tHEALTHY\tLAST CONTACT\tSERF")
+	for _, server := range health.Servers {
+		fmt.Fprintf(table, "%s\t%s\t%t\t%t\t%t\t%v\t%s\n", server.Name, server.Address, server.Leader, server.Voter, server.Healthy, server.LastContact, server.SerfStatus)
+	}
+	table.Flush()
+}
@@ -0,0 +1,268 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"redserenity.com/consul-bootstrap/config"
+)
+
+const (
+	PROFILE_AGENT_FILE     = "agent"
+	PROFILE_PORTS_FILE     = "ports"
+	PROFILE_TELEMETRY_FILE = "telemetry"
+
+	// PROFILE_CLIENT_ADDR serves the HTTP API on localhost and on the address other nodes reach the agent on, which the
+	// ZeroConf health check and -federate use. LoadAgentProfile replaces the template with -advertise-address when set.
+	PROFILE_CLIENT_ADDR = "127.0.0.1 {{ GetPrivateIP }}"
+)
+
+// profiles builds a fresh copy of every built-in profile, so overrides never leak between uses.
+var profiles = map[string]func() *Profile{
+	"dev": func() *Profile {
+		return &Profile{
+			Role:       ROLE_SERVER,
+			DataDir:    "/consul/data",
+			LogLevel:   "DEBUG",
+			BindAddr:   "127.0.0.1",
+			ClientAddr: "0.0.0.0",
+			Ports:      &config.PortsConfig{DNS: 8600, GRPC: 8502},
+			UIConfig:   &config.UIConfig{Enabled: config.Bool(true)},
+			Telemetry:  &config.TelemetryConfig{DisableHostname: config.Bool(true)},
+		}
+	},
+	"prod-server": func() *Profile {
+		return &Profile{
+			Role:       ROLE_SERVER,
+			DataDir:    "/consul/data",
+			LogLevel:   "INFO",
+			BindAddr:   `{{ GetPrivateIP }}`,
+			ClientAddr: PROFILE_CLIENT_ADDR,
+			Ports:      &config.PortsConfig{DNS: 8600, GRPC: 8502},
+			UIConfig:   &config.UIConfig{Enabled: config.Bool(true)},
+			Telemetry: &config.TelemetryConfig{
+				DisableHostname:         config.Bool(true),
+				PrometheusRetentionTime: "60s",
+			},
+		}
+	},
+	"prod-client": func() *Profile {
+		return &Profile{
+			Role:       ROLE_CLIENT,
+			DataDir:    "/consul/data",
+			LogLevel:   "INFO",
+			BindAddr:   `{{ GetPrivateIP }}`,
+			ClientAddr: PROFILE_CLIENT_ADDR,
+			Ports:      &config.PortsConfig{DNS: 8600, GRPC: 8502},
+			UIConfig:   &config.UIConfig{Enabled: config.Bool(false)},
+			Telemetry: &config.TelemetryConfig{
+				DisableHostname:         config.Bool(true),
+				PrometheusRetentionTime: "60s",
+			},
+		}
+	},
+	// edge nodes only serve local workloads, so DNS and gRPC are turned off and logging is kept quiet.
+	"edge": func() *Profile {
+		return &Profile{
+			Role:       ROLE_CLIENT,
+			DataDir:    "/consul/data",
+			LogLevel:   "WARN",
+			BindAddr:   `{{ GetPrivateIP }}`,
+			ClientAddr: PROFILE_CLIENT_ADDR,
+			Ports:      &config.PortsConfig{DNS: -1, GRPC: -1},
+			UIConfig:   &config.UIConfig{Enabled: config.Bool(false)},
+			Telemetry:  &config.TelemetryConfig{DisableHostname: config.Bool(true)},
+		}
+	},
+}
+
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func NewProfile(name string) (*Profile, error) {
+	newProfile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %s, must be one of %s", name, strings.Join(ProfileNames(), ", "))
+	}
+
+	profile := newProfile()
+	profile.Name = name
+
+	return profile, nil
+}
+
+func LoadProfileOverrides(file string) *ProfileOverrides {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	overrides := &ProfileOverrides{}
+	if err := json.Unmarshal(content, overrides); err != nil {
+		log.Fatalf("==> Invalid profile file %s: %s", file, err)
+	}
+
+	return overrides
+}
+
+// ApplyProfileOverrides applies the Set overrides of overrides and then those of nodeName.
+func ApplyProfileOverrides(profile *Profile, overrides *ProfileOverrides, nodeName string) error {
+	if err := ApplyProfileOverride(profile, overrides.Set); err != nil {
+		return err
+	}
+
+	if err := ApplyProfileOverride(profile, overrides.Nodes[nodeName]); err != nil {
+		return fmt.Errorf("node %s: %s", nodeName, err)
+	}
+
+	return nil
+}
+
+// ApplyProfileOverride decodes override, a JSON object using agent config keys, on top of profile.
+// Blocks are merged, so {"telemetry": {"statsd_address": "..."}} keeps the other telemetry settings.
+func ApplyProfileOverride(profile *Profile, override json.RawMessage) error {
+	if len(override) == 0 || string(override) == "null" {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(override))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(profile)
+}
+
+// ApplyProfileSet applies comma separated key=value overrides, e.g. log_level=TRACE,ports.grpc=-1.
+// Values are decoded as JSON when possible and used as strings otherwise.
+func ApplyProfileSet(profile *Profile, sets string) error {
+	for _, set := range strings.Split(sets, ",") {
+		if strings.TrimSpace(set) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(set, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("%s is not a key=value pair", set)
+		}
+
+		var value interface{}
+		isJson := json.Unmarshal([]byte(parts[1]), &value) == nil
+		if !isJson {
+			value = parts[1]
+		}
+
+		err := applyProfileKey(profile, parts[0], value)
+		if _, isTypeError := err.(*json.UnmarshalTypeError); isTypeError && isJson {
+			// e.g. datacenter=1, where the value only looks like a number.
+			err = applyProfileKey(profile, parts[0], parts[1])
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", parts[0], err)
+		}
+	}
+
+	return nil
+}
+
+func applyProfileKey(profile *Profile, key string, value interface{}) error {
+	keys := strings.Split(key, ".")
+	for i := len(keys) - 1; i >= 0; i-- {
+		value = map[string]interface{}{keys[i]: value}
+	}
+
+	override, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return ApplyProfileOverride(profile, override)
+}
+
+// ProfileConfigs splits profile into the agent config files it renders, keyed by file name.
+// The role config is included, with the ui setting of the profile.
+func ProfileConfigs(profile *Profile, bootstrapExpect int) map[string]*config.AgentConfig {
+	roleConfig := RoleConfig(profile.Role, bootstrapExpect)
+	if profile.UIConfig != nil {
+		roleConfig.UIConfig = profile.UIConfig
+	}
+
+	configs := map[string]*config.AgentConfig{
+		profile.Role: roleConfig,
+		PROFILE_AGENT_FILE: {
+			Datacenter:    profile.Datacenter,
+			DataDir:       profile.DataDir,
+			LogLevel:      profile.LogLevel,
+			BindAddr:      profile.BindAddr,
+			AdvertiseAddr: profile.AdvertiseAddr,
+			ClientAddr:    profile.ClientAddr,
+		},
+	}
+
+	if profile.Ports != nil {
+		configs[PROFILE_PORTS_FILE] = &config.AgentConfig{Ports: profile.Ports}
+	}
+
+	if profile.Telemetry != nil {
+		configs[PROFILE_TELEMETRY_FILE] = &config.AgentConfig{Telemetry: profile.Telemetry}
+	}
+
+	return configs
+}
+
+// SaveProfile writes every file of profile into path and removes the config of the other role.
+func SaveProfile(profile *Profile, bootstrapExpect int, path string) {
+	configs := ProfileConfigs(profile, bootstrapExpect)
+
+	for _, name := range profileFileNames(configs) {
+		if name == profile.Role {
+			saveRoleConfig(profile.Role, configs[name], path)
+			continue
+		}
+
+		log.Printf("==> Saving %s profile %s config in %s%s.", profile.Name, name, path, config.AgentConfigFile(name))
+
+		if _, err := config.SaveAgentConfig(path, name, configs[name], config.DefaultMode()); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// WriteProfile writes every file of profile to w, each preceded by a # line with the path it would be written to.
+func WriteProfile(w io.Writer, profile *Profile, bootstrapExpect int, path string) error {
+	configs := ProfileConfigs(profile, bootstrapExpect)
+
+	for _, name := range profileFileNames(configs) {
+		content, err := config.EncodeAgentConfig(configs[name])
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "# %s%s\n%s\n", path, config.AgentConfigFile(name), content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func profileFileNames(configs map[string]*config.AgentConfig) []string {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
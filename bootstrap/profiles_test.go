@@ -0,0 +1,184 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"redserenity.com/consul-bootstrap/config"
+)
+
+func mustProfile(t *testing.T, name string) *Profile {
+	t.Helper()
+
+	profile, err := NewProfile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return profile
+}
+
+func TestNewProfile(t *testing.T) {
+	for _, name := range ProfileNames() {
+		t.Run(name, func(t *testing.T) {
+			profile := mustProfile(t, name)
+			if profile.Name != name {
+				t.Fatalf("profile name is %q", profile.Name)
+			}
+
+			// Nodes are registered and health checked on their private address, so the HTTP API must listen on it.
+			if name != "dev" && profile.ClientAddr != PROFILE_CLIENT_ADDR {
+				t.Fatalf("client_addr is %q, want %q", profile.ClientAddr, PROFILE_CLIENT_ADDR)
+			}
+		})
+	}
+
+	if _, err := NewProfile("staging"); err == nil || !strings.Contains(err.Error(), "dev, edge, prod-client, prod-server") {
+		t.Fatalf("got error %v, want one listing the profiles", err)
+	}
+}
+
+func TestNewProfileReturnsCopies(t *testing.T) {
+	first := mustProfile(t, "prod-server")
+	first.Telemetry.StatsdAddress = "127.0.0.1:8125"
+	first.Ports.DNS = -1
+
+	second := mustProfile(t, "prod-server")
+	if second.Telemetry.StatsdAddress != "" || second.Ports.DNS != 8600 {
+		t.Fatal("changes to one profile leaked into the next")
+	}
+}
+
+func TestApplyProfileOverrides(t *testing.T) {
+	overrides := &ProfileOverrides{}
+	err := json.Unmarshal([]byte(`{
+		"set": {"datacenter": "eu1", "telemetry": {"statsd_address": "127.0.0.1:8125"}},
+		"nodes": {
+			"web-1": {"bind_addr": "10.0.0.5", "datacenter": "eu2"},
+			"web-2": {"log_level": "TRACE"}
+		}
+	}`), overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		node       string
+		datacenter string
+		bindAddr   string
+		logLevel   string
+	}{
+		{"web-1", "eu2", "10.0.0.5", "INFO"},
+		{"web-2", "eu1", "{{ GetPrivateIP }}", "TRACE"},
+		{"web-3", "eu1", "{{ GetPrivateIP }}", "INFO"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.node, func(t *testing.T) {
+			profile := mustProfile(t, "prod-client")
+			if err := ApplyProfileOverrides(profile, overrides, c.node); err != nil {
+				t.Fatal(err)
+			}
+
+			if profile.Datacenter != c.datacenter || profile.BindAddr != c.bindAddr || profile.LogLevel != c.logLevel {
+				t.Fatalf("got datacenter %q, bind_addr %q, log_level %q", profile.Datacenter, profile.BindAddr, profile.LogLevel)
+			}
+
+			// Blocks are merged, so the telemetry override keeps the profile's other telemetry settings.
+			want := &config.TelemetryConfig{DisableHostname: config.Bool(true), PrometheusRetentionTime: "60s", StatsdAddress: "127.0.0.1:8125"}
+			if !reflect.DeepEqual(profile.Telemetry, want) {
+				t.Fatalf("telemetry is %+v, want %+v", profile.Telemetry, want)
+			}
+		})
+	}
+}
+
+func TestApplyProfileOverridesRejectsUnknownKeys(t *testing.T) {
+	cases := []struct {
+		name      string
+		overrides string
+		err       string
+	}{
+		{"set", `{"set": {"log_leve": "DEBUG"}}`, "log_leve"},
+		{"node", `{"nodes": {"web-1": {"telemetry": {"statsd": "x"}}}}`, "node web-1"},
+		{"wrong type", `{"set": {"ports": {"dns": "off"}}}`, "dns"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			overrides := &ProfileOverrides{}
+			if err := json.Unmarshal([]byte(c.overrides), overrides); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ApplyProfileOverrides(mustProfile(t, "edge"), overrides, "web-1")
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got error %v, want one containing %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestApplyProfileSet(t *testing.T) {
+	cases := []struct {
+		set   string
+		check func(*Profile) bool
+	}{
+		{"log_level=TRACE", func(p *Profile) bool { return p.LogLevel == "TRACE" }},
+		{"ports.grpc=-1", func(p *Profile) bool { return p.Ports.GRPC == -1 && p.Ports.DNS == 8600 }},
+		{"datacenter=1", func(p *Profile) bool { return p.Datacenter == "1" }},
+		{"client_addr=0.0.0.0,log_level=WARN", func(p *Profile) bool { return p.ClientAddr == "0.0.0.0" && p.LogLevel == "WARN" }},
+		{"telemetry.statsd_address=127.0.0.1:8125", func(p *Profile) bool {
+			return p.Telemetry.StatsdAddress == "127.0.0.1:8125" && p.Telemetry.PrometheusRetentionTime == "60s"
+		}},
+		{"ui_config.enabled=false", func(p *Profile) bool { return !*p.UIConfig.Enabled }},
+		{"", func(p *Profile) bool { return p.LogLevel == "INFO" }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.set, func(t *testing.T) {
+			profile := mustProfile(t, "prod-server")
+			if err := ApplyProfileSet(profile, c.set); err != nil {
+				t.Fatal(err)
+			}
+
+			if !c.check(profile) {
+				t.Fatalf("%s was not applied: %+v", c.set, profile)
+			}
+		})
+	}
+}
+
+func TestApplyProfileSetErrors(t *testing.T) {
+	for _, set := range []string{"log_level", "=TRACE", "log_leve=TRACE", "ports.dns=off", "telemetry=1"} {
+		t.Run(set, func(t *testing.T) {
+			if err := ApplyProfileSet(mustProfile(t, "prod-server"), set); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestProfileConfigs(t *testing.T) {
+	profile := mustProfile(t, "prod-server")
+	profile.Datacenter = "eu1"
+
+	configs := ProfileConfigs(profile, 3)
+
+	names := profileFileNames(configs)
+	if want := []string{PROFILE_AGENT_FILE, PROFILE_PORTS_FILE, ROLE_SERVER, PROFILE_TELEMETRY_FILE}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("files are %v, want %v", names, want)
+	}
+
+	agent := configs[PROFILE_AGENT_FILE]
+	if agent.Datacenter != "eu1" || agent.ClientAddr != PROFILE_CLIENT_ADDR || agent.BindAddr != "{{ GetPrivateIP }}" {
+		t.Fatalf("agent config is %+v", agent)
+	}
+
+	server := configs[ROLE_SERVER]
+	if server.BootstrapExpect != 3 || server.UIConfig == nil || !*server.UIConfig.Enabled {
+		t.Fatalf("server config is %+v", server)
+	}
+}
@@ -0,0 +1,118 @@
+package bootstrap
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+const (
+	NODE_STATE_DEAD = "dead"
+
+	REAPER_AUDIT_PATH = "audit/reaper/"
+)
+
+func HeartbeatCheckId(nodeName string) string {
+	return "zeroconf-heartbeat-" + SanitizeNodeName(nodeName)
+}
+
+// PassHeartbeat keeps the TTL check of the node passing. Returns false if the node has no heartbeat check.
+func PassHeartbeat(zeroConfClient *consul.ConsulClient, nodeName string) bool {
+	err := zeroConfClient.Client.Agent().PassTTL(HeartbeatCheckId(nodeName), "daemon heartbeat at "+time.Now().UTC().Format(time.RFC3339))
+	if err == nil {
+		return true
+	}
+
+	if strings.Contains(err.Error(), "does not have associated TTL") || strings.Contains(err.Error(), "Unknown check") {
+		return false
+	}
+
+	log.Printf("==> Unable to pass the heartbeat check of %s: %s", nodeName, err)
+	return false
+}
+
+// HeartbeatStates returns the heartbeat check status by service ID for every node registered with a heartbeat.
+func HeartbeatStates(zeroConfClient *consul.ConsulClient) map[string]string {
+	entries, err := consul.GetServices(zeroConfClient, CLUSTER_SERVICE)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	states := map[string]string{}
+	for _, entry := range entries {
+		for _, check := range entry.Checks {
+			if strings.HasPrefix(check.CheckID, "zeroconf-heartbeat-") && check.ServiceID == entry.Service.ID {
+				states[entry.Service.ID] = check.Status
+			}
+		}
+	}
+
+	return states
+}
+
+// FindDeadNodes tracks since when each node's heartbeat is critical in its inventory record and returns
+// the nodes that have been critical for longer than reapAfter.
+func FindDeadNodes(zeroConfClient *consul.ConsulClient, reapAfter time.Duration) []*ClusterNode {
+	states := HeartbeatStates(zeroConfClient)
+	now := time.Now().UTC()
+
+	var dead []*ClusterNode
+	for _, node := range ListNodeRecords(zeroConfClient, "") {
+		status, hasHeartbeat := states[SanitizeNodeName(node.Name)]
+		if !hasHeartbeat || node.State == NODE_STATE_DEAD {
+			continue
+		}
+
+		if status != consulApi.HealthCritical {
+			if node.CriticalSince != nil {
+				node.CriticalSince = nil
+				saveNodeRecord(zeroConfClient, node)
+			}
+			continue
+		}
+
+		if node.CriticalSince == nil {
+			log.Printf("==> Heartbeat of %s is critical. Reaping it after %s.", node.Name, reapAfter)
+			node.CriticalSince = &now
+			saveNodeRecord(zeroConfClient, node)
+			continue
+		}
+
+		if now.Sub(*node.CriticalSince) >= reapAfter {
+			dead = append(dead, node)
+		}
+	}
+
+	return dead
+}
+
+// ReapNode deregisters the node's service and marks its inventory record dead.
+func ReapNode(zeroConfClient *consul.ConsulClient, node *ClusterNode) error {
+	if err := consul.DeregisterAgentService(zeroConfClient, SanitizeNodeName(node.Name)); err != nil {
+		return err
+	}
+
+	node.State = NODE_STATE_DEAD
+	return consul.SaveKVStruct(zeroConfClient, NodeRecordPath(node.ClusterId, node.Name), node)
+}
+
+func SaveReapAudit(zeroConfClient *consul.ConsulClient, reaped *ReapedNode) {
+	log.Printf("==> Reaped %s (cluster %s, %s): critical since %s, decommissioned: %t.", reaped.Node, reaped.ClusterId, reaped.Address, reaped.CriticalSince.Format(time.RFC3339), reaped.Decommissioned)
+	for _, message := range reaped.Errors {
+		log.Printf("==>   %s", message)
+	}
+
+	key := REAPER_AUDIT_PATH + reaped.ReapedAt.Format("20060102T150405Z") + "-" + SanitizeNodeName(reaped.Node)
+	if err := consul.SaveKVStruct(zeroConfClient, key, reaped); err != nil {
+		log.Printf("==> Unable to save the audit record of %s: %s", reaped.Node, err)
+	}
+}
+
+func saveNodeRecord(client *consul.ConsulClient, node *ClusterNode) {
+	if err := consul.SaveKVStruct(client, NodeRecordPath(node.ClusterId, node.Name), node); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package bootstrap
+
+import (
+	"testing"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+func TestPassHeartbeat(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	fake.addClusterService("web.1", consulApi.HealthCritical)
+	fake.addClusterService("web.2", "")
+
+	if !PassHeartbeat(client, "web.1") {
+		t.Fatal("heartbeat of web.1 was not passed")
+	}
+	if status := fake.checkStatus(HeartbeatCheckId("web.1")); status != consulApi.HealthPassing {
+		t.Fatalf("heartbeat of web.1 is %s", status)
+	}
+
+	if PassHeartbeat(client, "web.2") {
+		t.Fatal("heartbeat passed for a node registered without one")
+	}
+}
+
+func TestHeartbeatStates(t *testing.T) {
+	fake := newFakeConsul(t)
+
+	fake.addClusterService("node1", consulApi.HealthCritical)
+	fake.addClusterService("node2", consulApi.HealthPassing)
+	fake.addClusterService("node3", "")
+
+	states := HeartbeatStates(fake.client())
+	want := map[string]string{"node1": consulApi.HealthCritical, "node2": consulApi.HealthPassing}
+	if len(states) != len(want) || states["node1"] != want["node1"] || states["node2"] != want["node2"] {
+		t.Fatalf("states are %v, want %v", states, want)
+	}
+}
+
+func TestFindDeadNodes(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	earlier := time.Now().UTC().Add(-2 * time.Hour)
+	for _, node := range []*ClusterNode{
+		{Name: "critical", ClusterId: "web"},
+		{Name: "expired", ClusterId: "web", CriticalSince: &earlier},
+		{Name: "recovered", ClusterId: "web", CriticalSince: &earlier},
+		{Name: "reaped", ClusterId: "web", State: NODE_STATE_DEAD, CriticalSince: &earlier},
+		{Name: "no-heartbeat", ClusterId: "db"},
+	} {
+		fake.put(NodeRecordPath(node.ClusterId, node.Name), node)
+	}
+	fake.addClusterService("critical", consulApi.HealthCritical)
+	fake.addClusterService("expired", consulApi.HealthCritical)
+	fake.addClusterService("recovered", consulApi.HealthPassing)
+	fake.addClusterService("reaped", consulApi.HealthCritical)
+	fake.addClusterService("no-heartbeat", "")
+
+	dead := FindDeadNodes(client, time.Hour)
+	if len(dead) != 1 || dead[0].Name != "expired" {
+		t.Fatalf("dead nodes are %v", dead)
+	}
+
+	if node := GetNodeRecord(client, "web", "critical"); node.CriticalSince == nil {
+		t.Fatal("critical node has no critical since time")
+	}
+	if node := GetNodeRecord(client, "web", "recovered"); node.CriticalSince != nil {
+		t.Fatalf("recovered node is critical since %s", node.CriticalSince)
+	}
+
+	if err := ReapNode(client, dead[0]); err != nil {
+		t.Fatal(err)
+	}
+	if node := GetNodeRecord(client, "web", "expired"); node.State != NODE_STATE_DEAD {
+		t.Fatalf("reaped node has state %q", node.State)
+	}
+	if HeartbeatStates(client)["expired"] != "" {
+		t.Fatal("reaped node is still registered")
+	}
+
+	// Reaped nodes are not reported again.
+	if dead := FindDeadNodes(client, time.Hour); len(dead) != 0 {
+		t.Fatalf("dead nodes after the reap are %v", dead)
+	}
+}
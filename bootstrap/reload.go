@@ -0,0 +1,182 @@
+package bootstrap
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// reloadableKeys are the agent config keys a reload applies. Each entry also covers the keys below it.
+var reloadableKeys = []string{
+	"acl.tokens", "ca_file", "ca_path", "cert_file", "check", "checks", "discard_check_output", "http_config", "key_file",
+	"limits", "log_level", "node_meta", "raft_snapshot_interval", "raft_snapshot_threshold", "raft_trailing_logs",
+	"service", "services", "tls", "verify_incoming", "verify_incoming_rpc", "verify_outgoing", "verify_server_hostname", "watches",
+}
+
+// startupKeys are only read when the agent starts. A running agent has already joined, so they never require a restart.
+var startupKeys = []string{"retry_join", "retry_join_wan", "start_join", "start_join_wan"}
+
+// runningConfigFields maps agent config keys to the /v1/agent/self DebugConfig fields holding their running value.
+// Field names differ between Consul versions, the first one present is used.
+var runningConfigFields = map[string][]string{
+	"acl.default_policy":           {"ACLResolverSettings.ACLDefaultPolicy", "ACLDefaultPolicy"},
+	"acl.enable_token_persistence": {"ACLTokens.EnablePersistence", "ACLEnableTokenPersistence"},
+	"acl.enable_token_replication": {"ACLTokenReplication"},
+	"acl.enabled":                  {"ACLsEnabled"},
+	"auto_config.enabled":          {"AutoConfig.Enabled"},
+	"bootstrap_expect":             {"BootstrapExpect"},
+	"connect.enabled":              {"ConnectEnabled"},
+	"data_dir":                     {"DataDir"},
+	"datacenter":                   {"Datacenter"},
+	"log_level":                    {"Logging.LogLevel", "LogLevel"},
+	"ports.dns":                    {"DNSPort"},
+	"ports.grpc":                   {"GRPCPort"},
+	"ports.http":                   {"HTTPPort"},
+	"ports.https":                  {"HTTPSPort"},
+	"ports.serf_lan":               {"SerfPortLAN"},
+	"ports.server":                 {"ServerPort"},
+	"primary_datacenter":           {"PrimaryDatacenter"},
+	"server":                       {"ServerMode"},
+	"ui_config.enabled":            {"UIConfig.Enabled", "UIEnabled"},
+}
+
+// agentTokens are the tokens in the config the agent token API can set on a running agent.
+var agentTokens = []struct {
+	key, name string
+	update    func(*consul.ConsulClient, string) error
+}{
+	{"acl.tokens.agent", "agent", consul.UpdateAgentToken},
+	{"acl.tokens.default", "default", consul.UpdateDefaultToken},
+}
+
+// PushAgentTokens sets the agent and default tokens changed in the config on the running agent, so they are used
+// before the reload. A token is only set once it resolves, a token the servers do not know yet is left to the reload.
+func PushAgentTokens(client *consul.ConsulClient, changes map[string]interface{}) {
+	for _, token := range agentTokens {
+		secret, ok := changes[token.key].(string)
+		if !ok || secret == "" {
+			continue
+		}
+
+		tokenClient := *client
+		tokenClient.Token = secret
+		if _, err := consul.GetSelfToken(&tokenClient); err != nil {
+			log.Printf("==> The new %s token does not resolve yet (%s). The agent picks it up from the config on reload.", token.name, err)
+			continue
+		}
+
+		if err := token.update(client, secret); err != nil {
+			log.Printf("==> Unable to set the %s token on the agent (%s). It picks it up from the config on reload.", token.name, err)
+			continue
+		}
+
+		log.Printf("==> Set the new %s token on the agent.", token.name)
+	}
+}
+
+// RestartRequired returns the changed keys the running agent has not applied and cannot apply on reload.
+// Call it after the agent was reloaded.
+func RestartRequired(client *consul.ConsulClient, changes map[string]interface{}) []string {
+	var debugConfig map[string]interface{}
+	if self, err := consul.GetAgentSelf(client); err == nil {
+		debugConfig = self["DebugConfig"]
+	} else {
+		log.Printf("==> Unable to read the running agent config (%s).", err)
+	}
+
+	var restart []string
+	for key, value := range changes {
+		if matchesKey(key, startupKeys) {
+			continue
+		}
+
+		if key == "encrypt" {
+			if gossipKey, ok := value.(string); !ok || !gossipKeyInUse(client, gossipKey) {
+				restart = append(restart, key)
+			}
+			continue
+		}
+
+		if running, ok := runningValue(debugConfig, key); ok {
+			if fmt.Sprint(running) == fmt.Sprint(value) {
+				continue
+			}
+			if matchesKey(key, reloadableKeys) {
+				log.Printf("==> The agent did not pick up %s on reload.", key)
+			}
+			restart = append(restart, key)
+			continue
+		}
+
+		if !matchesKey(key, reloadableKeys) {
+			restart = append(restart, key)
+		}
+	}
+
+	sort.Strings(restart)
+	return restart
+}
+
+func matchesKey(key string, keys []string) bool {
+	for _, prefix := range keys {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func runningValue(debugConfig map[string]interface{}, key string) (interface{}, bool) {
+	for _, field := range runningConfigFields[key] {
+		var value interface{} = debugConfig
+		for _, part := range strings.Split(field, ".") {
+			values, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value, ok = values[part]
+			if !ok {
+				value = nil
+				break
+			}
+		}
+
+		if value != nil {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// gossipKeyInUse checks that gossipKey is the primary key of every LAN keyring of the agent.
+func gossipKeyInUse(client *consul.ConsulClient, gossipKey string) bool {
+	keyring, err := consul.ListKeyring(client)
+	if err != nil {
+		return false
+	}
+
+	inUse := false
+	for _, ring := range keyring {
+		if ring.WAN {
+			continue
+		}
+
+		if _, isInstalled := ring.Keys[gossipKey]; !isInstalled {
+			return false
+		}
+
+		if _, isPrimary := ring.PrimaryKeys[gossipKey]; ring.PrimaryKeys != nil && !isPrimary {
+			return false
+		}
+
+		inUse = true
+	}
+
+	return inUse
+}
@@ -0,0 +1,112 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// tokenAgent serves the agent token API and resolves tokens like an agent does: requests without a token
+// act as its default token.
+type tokenAgent struct {
+	client *consul.ConsulClient
+
+	mu        sync.Mutex
+	accessors map[string]string
+	set       map[string]string
+}
+
+// newTokenAgent starts an agent that resolves the secrets of accessors, mapped secret to accessor ID.
+func newTokenAgent(t *testing.T, accessors map[string]string) *tokenAgent {
+	agent := &tokenAgent{accessors: accessors, set: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/agent/token/", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Token string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+		agent.set[strings.TrimPrefix(r.URL.Path, "/v1/agent/token/")] = body.Token
+	})
+	mux.HandleFunc("/v1/acl/token/self", func(w http.ResponseWriter, r *http.Request) {
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+
+		secret := r.Header.Get("X-Consul-Token")
+		if secret == "" {
+			secret = agent.set["default"]
+		}
+
+		accessor, ok := agent.accessors[secret]
+		if !ok {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(&consulApi.ACLToken{AccessorID: accessor, SecretID: secret}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	config := consulApi.DefaultConfig()
+	config.Address = server.URL
+	config.Token = ""
+
+	client, err := consulApi.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.client = &consul.ConsulClient{Client: client}
+
+	return agent
+}
+
+func (agent *tokenAgent) token(name string) string {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+
+	return agent.set[name]
+}
+
+func TestPushAgentTokens(t *testing.T) {
+	agent := newTokenAgent(t, map[string]string{"agent-secret": "agent-accessor", "default-secret": "default-accessor"})
+
+	PushAgentTokens(agent.client, map[string]interface{}{
+		"acl.tokens.agent":   "agent-secret",
+		"acl.tokens.default": "default-secret",
+	})
+
+	if got := agent.token("agent"); got != "agent-secret" {
+		t.Fatalf("agent token is %q", got)
+	}
+
+	// Requests without a token now act as the pushed default token.
+	self, err := consul.GetSelfToken(agent.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self.AccessorID != "default-accessor" {
+		t.Fatalf("requests without a token use %s, want default-accessor", self.AccessorID)
+	}
+}
+
+func TestPushAgentTokensUnresolved(t *testing.T) {
+	agent := newTokenAgent(t, map[string]string{})
+
+	PushAgentTokens(agent.client, map[string]interface{}{"acl.tokens.agent": "unknown-secret", "encrypt": "key"})
+
+	if got := agent.token("agent"); got != "" {
+		t.Fatalf("pushed agent token %q that does not resolve", got)
+	}
+}
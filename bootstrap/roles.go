@@ -0,0 +1,112 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+const (
+	ROLE_SERVER = "server"
+	ROLE_CLIENT = "client"
+)
+
+func ClusterServersPath(clusterId string) string {
+	return ClusterPath(clusterId) + "servers"
+}
+
+// ClaimServerSlot records nodeName as one of the cluster servers and returns the declared cluster size.
+// The first server declares the size, later servers must agree with it and registration fails once every slot is taken.
+func ClaimServerSlot(client *consul.ConsulClient, clusterId, nodeName string, serverCount int) (int, error) {
+	for {
+		pair, err := consul.GetKVPair(client, ClusterServersPath(clusterId))
+		if err != nil {
+			return 0, err
+		}
+
+		servers := &ClusterServers{}
+		var index uint64
+		if pair != nil {
+			if err := json.Unmarshal(pair.Value, servers); err != nil {
+				return 0, err
+			}
+			index = pair.ModifyIndex
+		}
+
+		if servers.Expect == 0 {
+			if serverCount < 1 {
+				return 0, fmt.Errorf("cluster %s has no declared server count. Use -server-count to declare it", clusterId)
+			}
+			servers.Expect = serverCount
+		} else if serverCount > 0 && serverCount != servers.Expect {
+			return 0, fmt.Errorf("cluster %s was declared with %d servers, not %d", clusterId, servers.Expect, serverCount)
+		}
+
+		for _, server := range servers.Servers {
+			if server == nodeName {
+				log.Printf("==> %s already holds a server slot in cluster %s (%d of %d).", nodeName, clusterId, len(servers.Servers), servers.Expect)
+				return servers.Expect, nil
+			}
+		}
+
+		if len(servers.Servers) >= servers.Expect {
+			return 0, fmt.Errorf("cluster %s already has all %d declared servers (%v). Register %s as a client instead", clusterId, servers.Expect, servers.Servers, nodeName)
+		}
+
+		servers.Servers = append(servers.Servers, nodeName)
+
+		saved, err := consul.SaveKVStructCAS(client, ClusterServersPath(clusterId), servers, index)
+		if err != nil {
+			return 0, err
+		}
+
+		if saved {
+			log.Printf("==> Claimed server slot %d of %d in cluster %s.", len(servers.Servers), servers.Expect, clusterId)
+			return servers.Expect, nil
+		}
+
+		// Another server registered concurrently, try again with the updated list.
+	}
+}
+
+// RoleConfig returns the agent config of role. bootstrap_expect is left out when bootstrapExpect is 0.
+func RoleConfig(role string, bootstrapExpect int) *config.AgentConfig {
+	if role == ROLE_SERVER {
+		return &config.AgentConfig{
+			Server:          config.Bool(true),
+			BootstrapExpect: bootstrapExpect,
+			UIConfig:        &config.UIConfig{Enabled: config.Bool(true)},
+			Connect:         &config.ConnectConfig{Enabled: config.Bool(true)},
+		}
+	}
+
+	return &config.AgentConfig{
+		Server:   config.Bool(false),
+		UIConfig: &config.UIConfig{Enabled: config.Bool(false)},
+	}
+}
+
+// SaveRoleConfig writes the server or client config and removes the config of the other role.
+func SaveRoleConfig(role string, bootstrapExpect int, path string) {
+	saveRoleConfig(role, RoleConfig(role, bootstrapExpect), path)
+}
+
+func saveRoleConfig(role string, roleConfig *config.AgentConfig, path string) {
+	stale := ROLE_CLIENT
+	if role == ROLE_CLIENT {
+		stale = ROLE_SERVER
+	}
+
+	log.Printf("==> Saving %s configuration in %s%s.", role, path, config.AgentConfigFile(role))
+
+	if _, err := config.SaveAgentConfig(path, role, roleConfig, config.DefaultMode()); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := config.RemoveAgentConfig(path, stale); err != nil {
+		log.Fatal(err)
+	}
+}
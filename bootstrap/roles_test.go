@@ -0,0 +1,69 @@
+package bootstrap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClaimServerSlot(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+
+	// The first server declares the size, a server claiming again keeps its slot.
+	claims := []struct {
+		node  string
+		count int
+	}{{"server1", 2}, {"server2", 0}, {"server1", 0}}
+
+	for _, claim := range claims {
+		expect, err := ClaimServerSlot(client, "web", claim.node, claim.count)
+		if err != nil {
+			t.Fatalf("%s: %s", claim.node, err)
+		}
+		if expect != 2 {
+			t.Fatalf("%s: expect is %d, want 2", claim.node, expect)
+		}
+	}
+
+	servers := &ClusterServers{}
+	fake.get("clusters/web/servers", servers)
+	if want := []string{"server1", "server2"}; !reflect.DeepEqual(servers.Servers, want) {
+		t.Fatalf("servers are %v, want %v", servers.Servers, want)
+	}
+}
+
+func TestClaimServerSlotRefused(t *testing.T) {
+	fake := newFakeConsul(t)
+	client := fake.client()
+	fake.put("clusters/web/servers", &ClusterServers{Expect: 1, Servers: []string{"server1"}})
+
+	cases := []struct {
+		name, node string
+		count      int
+		err        string
+	}{
+		{"all slots taken", "server2", 0, "already has all 1 declared servers"},
+		{"different count", "server2", 3, "was declared with 1 servers, not 3"},
+		{"slot already held", "server1", 0, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ClaimServerSlot(client, "web", c.node, c.count)
+			if c.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got error %v, want %q", err, c.err)
+			}
+		})
+	}
+
+	if _, err := ClaimServerSlot(client, "db", "server1", 0); err == nil || !strings.Contains(err.Error(), "-server-count") {
+		t.Fatalf("got error %v for a cluster without a declared count", err)
+	}
+}
@@ -0,0 +1,93 @@
+package bootstrap
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// DetectAdvertiseAddress returns the local address used to reach the ZeroConf server.
+func DetectAdvertiseAddress(zeroConfAddress string) string {
+	host := zeroConfAddress
+	port := "8500"
+
+	if parsed, err := url.Parse(zeroConfAddress); err == nil && parsed.Host != "" {
+		host = parsed.Host
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+
+	// UDP "connections" do not send any packets, they only select the outgoing interface.
+	conn, err := net.Dial("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		log.Fatalf("==> Unable to detect the advertise address (%s). Use -advertise-address to set it.", err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// NewClusterServiceRegistration describes a cluster member, including a TCP health check against its Serf LAN port.
+// Serf listens on the bind address, which other nodes must reach anyway, while the HTTP API may only listen on
+// loopback. With a heartbeat TTL the node also has to keep a TTL check passing, see HeartbeatCheckId.
+func NewClusterServiceRegistration(service *ClusterService, heartbeatTtl time.Duration) *consulApi.AgentServiceRegistration {
+	meta := map[string]string{
+		"cluster_id":    service.ClusterId,
+		"role":          service.Role,
+		"rpc_port":      strconv.Itoa(service.RpcPort),
+		"serf_lan_port": strconv.Itoa(service.SerfPort),
+	}
+	tags := []string{ClusterTag(service.ClusterId), "role=" + service.Role}
+
+	if service.Datacenter != "" {
+		meta["datacenter"] = service.Datacenter
+		tags = append(tags, "dc="+service.Datacenter)
+	}
+
+	if service.ConsulVersion != "" {
+		meta["consul_version"] = service.ConsulVersion
+	}
+
+	if service.HttpsPort > 0 {
+		meta["https_port"] = strconv.Itoa(service.HttpsPort)
+	}
+
+	registration := &consulApi.AgentServiceRegistration{
+		ID:      SanitizeNodeName(service.NodeName),
+		Name:    CLUSTER_SERVICE,
+		Address: service.Address,
+		Port:    service.HttpPort,
+		Tags:    tags,
+		Meta:    meta,
+		Check: &consulApi.AgentServiceCheck{
+			Name:     "Consul Serf LAN",
+			TCP:      net.JoinHostPort(service.Address, strconv.Itoa(service.SerfPort)),
+			Interval: "10s",
+			Timeout:  "5s",
+		},
+	}
+
+	if heartbeatTtl > 0 {
+		registration.Checks = consulApi.AgentServiceChecks{
+			registration.Check,
+			&consulApi.AgentServiceCheck{
+				CheckID: HeartbeatCheckId(service.NodeName),
+				Name:    "ZeroConf heartbeat",
+				TTL:     heartbeatTtl.String(),
+				Status:  consulApi.HealthPassing,
+			},
+		}
+		registration.Check = nil
+	}
+
+	return registration
+}
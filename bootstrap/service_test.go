@@ -0,0 +1,86 @@
+package bootstrap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testClusterService() *ClusterService {
+	return &ClusterService{
+		NodeName:      "web.1",
+		ClusterId:     "web",
+		Datacenter:    "eu1",
+		Role:          ROLE_SERVER,
+		Address:       "10.0.0.5",
+		ConsulVersion: "1.9.0",
+		HttpPort:      8500,
+		HttpsPort:     8501,
+		RpcPort:       8300,
+		SerfPort:      9301,
+	}
+}
+
+func TestNewClusterServiceRegistration(t *testing.T) {
+	registration := NewClusterServiceRegistration(testClusterService(), 0)
+
+	if registration.ID != "web_2e1" || registration.Name != CLUSTER_SERVICE || registration.Address != "10.0.0.5" || registration.Port != 8500 {
+		t.Fatalf("registration is %+v", registration)
+	}
+
+	if want := []string{"cluster=web", "role=server", "dc=eu1"}; !reflect.DeepEqual(registration.Tags, want) {
+		t.Fatalf("tags are %v, want %v", registration.Tags, want)
+	}
+
+	want := map[string]string{
+		"cluster_id":     "web",
+		"role":           ROLE_SERVER,
+		"rpc_port":       "8300",
+		"serf_lan_port":  "9301",
+		"datacenter":     "eu1",
+		"consul_version": "1.9.0",
+		"https_port":     "8501",
+	}
+	if !reflect.DeepEqual(registration.Meta, want) {
+		t.Fatalf("meta is %v, want %v", registration.Meta, want)
+	}
+
+	// The check must not depend on client_addr, which is loopback without a profile.
+	if registration.Check == nil || registration.Check.TCP != "10.0.0.5:9301" || registration.Check.HTTP != "" {
+		t.Fatalf("check is %+v, want a TCP check on the Serf LAN port", registration.Check)
+	}
+}
+
+func TestNewClusterServiceRegistrationOptionalMeta(t *testing.T) {
+	service := testClusterService()
+	service.Datacenter, service.ConsulVersion, service.HttpsPort = "", "", 0
+
+	registration := NewClusterServiceRegistration(service, 0)
+
+	for _, key := range []string{"datacenter", "consul_version", "https_port"} {
+		if _, ok := registration.Meta[key]; ok {
+			t.Errorf("meta has %s without a value for it", key)
+		}
+	}
+
+	if want := []string{"cluster=web", "role=server"}; !reflect.DeepEqual(registration.Tags, want) {
+		t.Fatalf("tags are %v, want %v", registration.Tags, want)
+	}
+}
+
+func TestNewClusterServiceRegistrationHeartbeat(t *testing.T) {
+	registration := NewClusterServiceRegistration(testClusterService(), 90*time.Second)
+
+	if registration.Check != nil || len(registration.Checks) != 2 {
+		t.Fatalf("checks are %+v, want the TCP and the heartbeat check", registration.Checks)
+	}
+
+	heartbeat := registration.Checks[1]
+	if heartbeat.CheckID != HeartbeatCheckId("web.1") || heartbeat.TTL != "1m30s" || heartbeat.Status != "passing" {
+		t.Fatalf("heartbeat check is %+v", heartbeat)
+	}
+
+	if registration.Checks[0].TCP != "10.0.0.5:9301" {
+		t.Fatalf("first check is %+v, want the TCP check", registration.Checks[0])
+	}
+}
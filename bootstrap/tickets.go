@@ -0,0 +1,226 @@
+package bootstrap
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+	"redserenity.com/consul-bootstrap/templates"
+)
+
+const (
+	TICKET_PENDING  = "pending"
+	TICKET_REDEEMED = "redeemed"
+	TICKET_EXPIRED  = "expired"
+)
+
+func TicketPath(ticketId string) string {
+	return "tickets/" + ticketId
+}
+
+func TicketTokenPath(ticketId string) string {
+	return "tickets/" + ticketId + "/token"
+}
+
+func TicketPolicyName(ticketId string) string {
+	return "zeroconf-ticket-" + ticketId
+}
+
+func NodeRegistrationPolicyName(nodeName string) string {
+	return "zeroconf-node-" + SanitizeNodeName(nodeName)
+}
+
+func NodeRegistrationPolicyDescription(nodeName string) string {
+	return "Registration policy for node " + nodeName
+}
+
+// CreateTicket issues a single-use join ticket for nodeName. The returned secret is the ticket the node redeems.
+// The ticket itself is a short lived ACL token that can only access its own record in the KV store.
+func CreateTicket(client *consul.ConsulClient, nodeName, clusterId string, ttl time.Duration) (*Ticket, string) {
+	log.Printf("==> Creating join ticket for %s (valid for %s).", nodeName, ttl)
+
+	nodeToken := setupNodeRegistrationToken(client, nodeName, clusterId)
+
+	ticket := &Ticket{
+		ID:        GenerateUUID(),
+		Node:      nodeName,
+		ClusterId: clusterId,
+		Status:    TICKET_PENDING,
+		CreatedAt: time.Now().UTC(),
+	}
+	ticket.ExpiresAt = ticket.CreatedAt.Add(ttl)
+
+	rules, err := config.GetTemplate("TicketPolicy", templates.TICKET_POLICY, ticket)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	policy, err := consul.CreatePolicy(client, TicketPolicyName(ticket.ID), "Join ticket for node "+nodeName, rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := consul.SaveKV(client, TicketTokenPath(ticket.ID), nodeToken); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := consul.SaveKVStruct(client, TicketPath(ticket.ID), ticket); err != nil {
+		log.Fatal(err)
+	}
+
+	ticketToken, err := consul.CreateExpiringToken(client, ticket.ID, "Join ticket for node "+nodeName, policy, ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return ticket, ticketToken.SecretID
+}
+
+// RedeemTicket exchanges the ticket the client is connected with for the node's registration token. A ticket can only be redeemed once.
+func RedeemTicket(ticketClient *consul.ConsulClient, nodeName string) string {
+	log.Printf("==> Redeeming join ticket for %s.", nodeName)
+
+	self, err := consul.GetSelfToken(ticketClient)
+	if err != nil {
+		log.Fatalf("==> Join ticket is invalid or has expired: %s", err)
+	}
+
+	pair, err := consul.GetKVPair(ticketClient, TicketPath(self.AccessorID))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if pair == nil {
+		log.Fatal("==> Join ticket is not known to the ZeroConf Server.")
+	}
+
+	ticket := &Ticket{}
+	if err := json.Unmarshal(pair.Value, ticket); err != nil {
+		log.Fatal(err)
+	}
+
+	if ticket.Node != nodeName {
+		log.Fatalf("==> Join ticket was issued for node %s, not %s.", ticket.Node, nodeName)
+	}
+
+	if ticket.Status != TICKET_PENDING {
+		log.Fatalf("==> Join ticket has already been %s.", ticket.Status)
+	}
+
+	now := time.Now().UTC()
+	if now.After(ticket.ExpiresAt) {
+		log.Fatal("==> Join ticket has expired.")
+	}
+
+	ticket.Status = TICKET_REDEEMED
+	ticket.RedeemedAt = &now
+
+	value, err := json.MarshalIndent(ticket, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nodeToken, claimed, err := consul.ClaimKV(ticketClient, pair, value, TicketTokenPath(ticket.ID))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !claimed {
+		log.Fatal("==> Join ticket has already been redeemed.")
+	}
+
+	log.Printf("==> Join ticket redeemed.")
+	return nodeToken
+}
+
+// SweepTickets marks expired tickets and removes the ACL objects of tickets that can no longer be redeemed.
+func SweepTickets(client *consul.ConsulClient) {
+	pairs, err := consul.ListKV(client, "tickets/")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	for _, pair := range pairs {
+		ticket := &Ticket{}
+		if json.Unmarshal(pair.Value, ticket) != nil || ticket.ID == "" || TicketPath(ticket.ID) != pair.Key {
+			continue
+		}
+
+		if ticket.Status == TICKET_PENDING && now.After(ticket.ExpiresAt) {
+			log.Printf("==> Join ticket %s for %s expired unused.", ticket.ID, ticket.Node)
+
+			ticket.Status = TICKET_EXPIRED
+			if err := consul.SaveKVStruct(client, pair.Key, ticket); err != nil {
+				log.Fatal(err)
+			}
+
+			if err := consul.DeleteKV(client, TicketTokenPath(ticket.ID)); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if ticket.Status == TICKET_PENDING {
+			continue
+		}
+
+		if consul.PolicyExistsByName(client, TicketPolicyName(ticket.ID)) {
+			// The ticket token may already be gone through its expiration TTL.
+			_ = consul.DeleteToken(client, ticket.ID)
+
+			if err := consul.DeletePolicyByName(client, TicketPolicyName(ticket.ID)); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+func setupNodeRegistrationToken(client *consul.ConsulClient, nodeName, clusterId string) string {
+	policyName := NodeRegistrationPolicyName(nodeName)
+	description := NodeRegistrationPolicyDescription(nodeName)
+
+	if err := migrateLegacyPolicy(client, "zeroconf-node-"+LegacyNodeName(nodeName), policyName, description); err != nil {
+		log.Fatal(err)
+	}
+
+	rules := registrationRules(client, clusterId)
+
+	policy, err := consul.GetPolicyByName(client, policyName)
+	if err != nil || policy == nil {
+		policy, err = consul.CreatePolicy(client, policyName, description, rules)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if policy.Rules != rules {
+		// Policies created by earlier versions granted more than the node needs.
+		log.Printf("==> Updating registration policy %s.", policyName)
+
+		policy.Rules = rules
+		if policy, err = consul.UpdatePolicy(client, policy); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	token, err := consul.CreatePolicyToken(client, "Registration Token for node "+nodeName, policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return token.SecretID
+}
+
+func GenerateUUID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal(err)
+	}
+
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
@@ -0,0 +1,222 @@
+package bootstrap
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"redserenity.com/consul-bootstrap/certs"
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+	"redserenity.com/consul-bootstrap/secret"
+)
+
+const (
+	CA_VALIDITY = 5 * 365 * 24 * time.Hour
+
+	CA_FILE        = "ca.pem"
+	CA_KEY_FILE    = "ca-key.pem"
+	AGENT_FILE     = "agent.pem"
+	AGENT_KEY_FILE = "agent-key.pem"
+
+	AGENT_HTTPS_PORT = 8501
+)
+
+func CAPath(clusterId string) string {
+	return ClusterPath(clusterId) + "tls/ca"
+}
+
+// CreateClusterCA stores a new CA on the ZeroConf server. Returns nil if the cluster already has a CA.
+func CreateClusterCA(client *consul.ConsulClient, clusterId, encryptionKey string) *certs.Certificate {
+	log.Printf("==> Creating certificate authority for cluster %s.", clusterId)
+
+	ca, err := certs.CreateCA("Consul ZeroConf CA "+clusterId, CA_VALIDITY, certs.SystemClock{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sealed := &certs.Certificate{CertPEM: ca.CertPEM, KeyPEM: sealSecret("CA private key", ca.KeyPEM, encryptionKey)}
+	content, err := json.Marshal(sealed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	created, err := consul.CreateKV(client, CAPath(clusterId), string(content))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !created {
+		return nil
+	}
+
+	return ca
+}
+
+// FetchClusterCA reads the cluster CA from the ZeroConf server, creating it first if the cluster has none.
+func FetchClusterCA(client *consul.ConsulClient, clusterId, encryptionKey string) *certs.Certificate {
+	for {
+		if ca := GetClusterCA(client, clusterId, encryptionKey); ca != nil {
+			return ca
+		}
+
+		if ca := CreateClusterCA(client, clusterId, encryptionKey); ca != nil {
+			return ca
+		}
+	}
+}
+
+// GetClusterCA reads the cluster CA from the ZeroConf server. Returns nil if the cluster has none.
+func GetClusterCA(client *consul.ConsulClient, clusterId, encryptionKey string) *certs.Certificate {
+	pair, err := consul.GetKVPair(client, CAPath(clusterId))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if pair == nil {
+		return nil
+	}
+
+	ca := &certs.Certificate{}
+	if err := json.Unmarshal(pair.Value, ca); err != nil {
+		log.Fatal(err)
+	}
+
+	if ca.KeyPEM, err = secret.Decrypt(encryptionKey, ca.KeyPEM); err != nil {
+		log.Fatal(err)
+	}
+
+	return ca
+}
+
+// LoadLocalCA reads a CA kept in caDir instead of on the ZeroConf server, creating it first if missing.
+func LoadLocalCA(caDir, encryptionKey string) *certs.Certificate {
+	certPEM, certErr := ioutil.ReadFile(caDir + CA_FILE)
+	keyPEM, keyErr := ioutil.ReadFile(caDir + CA_KEY_FILE)
+
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		log.Printf("==> Creating certificate authority in %s.", caDir)
+
+		ca, err := certs.CreateCA("Consul ZeroConf CA", CA_VALIDITY, certs.SystemClock{})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := config.SaveConfigAtomic(caDir, CA_KEY_FILE, sealSecret("CA private key", ca.KeyPEM, encryptionKey), 0600); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := config.SaveConfigAtomic(caDir, CA_FILE, ca.CertPEM, 0644); err != nil {
+			log.Fatal(err)
+		}
+
+		return ca
+	}
+
+	if certErr != nil {
+		log.Fatal(certErr)
+	}
+	if keyErr != nil {
+		log.Fatal(keyErr)
+	}
+
+	key, err := secret.Decrypt(encryptionKey, string(keyPEM))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return &certs.Certificate{CertPEM: string(certPEM), KeyPEM: key}
+}
+
+// CAOutlives reports whether certificates issued by ca now stay valid for longer than threshold, and logs when they do not.
+// Issued certificates never outlive the CA, so renewing them is pointless once the CA itself is that close to expiring.
+func CAOutlives(ca *certs.Certificate, clock certs.Clock, threshold time.Duration) bool {
+	caCert, err := certs.ParseCert(ca.CertPEM)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if certs.CanRenew(caCert, clock, threshold) {
+		return true
+	}
+
+	log.Printf("==> The CA %s expires in %d days, so certificates it issues expire by then as well. Replace the CA and re-issue the agent certificates.",
+		caCert.Subject.CommonName, certs.DaysUntilExpiry(caCert, clock))
+
+	return false
+}
+
+// IssueAgentCert issues a server.<dc>.consul certificate for servers and a client.<dc>.consul certificate for clients.
+func IssueAgentCert(ca *certs.Certificate, nodeName, datacenter string, server bool, days int, clock certs.Clock) *certs.Certificate {
+	role := "client"
+	if server {
+		role = "server"
+	}
+
+	log.Printf("==> Issuing %s certificate for %s in %s.", role, nodeName, datacenter)
+
+	cert, err := certs.IssueCert(ca, &certs.CertRequest{
+		CommonName:  role + "." + datacenter + ".consul",
+		DNSNames:    []string{role + "." + datacenter + ".consul", nodeName, "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		Validity:    time.Duration(days) * 24 * time.Hour,
+	}, clock)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return cert
+}
+
+// SaveAgentTls writes the CA certificate, agent certificate & key and a tls config referencing them into path.
+func SaveAgentTls(ca, cert *certs.Certificate, server bool, path, name string) {
+	log.Printf("==> Saving TLS certificates and %s%s.", path, config.AgentConfigFile(name))
+
+	if err := config.SaveConfigAtomic(path, CA_FILE, ca.CertPEM, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := config.SaveConfigAtomic(path, AGENT_KEY_FILE, cert.KeyPEM, 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	// The CA is appended so clients pinning the CA fingerprint can verify the chain.
+	if err := config.SaveConfigAtomic(path, AGENT_FILE, cert.CertPEM+ca.CertPEM, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	_, err := config.SaveAgentConfig(path, name, &config.AgentConfig{
+		CAFile:               path + CA_FILE,
+		CertFile:             path + AGENT_FILE,
+		KeyFile:              path + AGENT_KEY_FILE,
+		VerifyIncomingRPC:    config.Bool(server),
+		VerifyOutgoing:       config.Bool(true),
+		VerifyServerHostname: config.Bool(true),
+		Ports:                &config.PortsConfig{HTTPS: AGENT_HTTPS_PORT},
+	}, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LoadAgentCert reads a certificate previously written by SaveAgentTls. Returns nil if the file does not exist.
+func LoadAgentCert(path, file string) *x509.Certificate {
+	content, err := ioutil.ReadFile(path + file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cert, err := certs.ParseCert(string(content))
+	if err != nil {
+		log.Fatalf("==> Unable to parse %s%s: %s", path, file, err)
+	}
+
+	return cert
+}
@@ -1,11 +1,130 @@
 package bootstrap
 
+import (
+	"encoding/json"
+	"time"
+
+	"redserenity.com/consul-bootstrap/config"
+)
+
 type ClusterNode struct {
-	Name    string
-	Address string
+	Name          string
+	SanitizedName string
+	Address       string
+	MachineId     string `json:",omitempty"`
+	Role          string
+	ClusterId     string
+	Datacenter    string
+	ConsulVersion string
+	RegisteredAt  time.Time
+	LastSeen      time.Time
+	TokenAccessor string     `json:",omitempty"`
+	GossipKeyId   string     `json:",omitempty"`
+	State         string     `json:",omitempty"`
+	CriticalSince *time.Time `json:",omitempty"`
 }
 
+// ZeroConf is the zeroconf.json client profile, everything a node needs to reach the ZeroConf Server.
+// Files written before Version was added only hold Address and Token and load as version 0.
+// Node is set when Token was redeemed from a join ticket and is scoped to that node.
 type ZeroConf struct {
-	Address string
-	Token   string
+	Version       int
+	Address       string
+	Token         string
+	CaFingerprint string `json:",omitempty"`
+	ClusterId     string `json:",omitempty"`
+	Datacenter    string `json:",omitempty"`
+	Node          string `json:",omitempty"`
+}
+
+type GossipRotation struct {
+	Stage     string
+	OldKey    string
+	NewKey    string
+	StartedAt time.Time
+}
+
+type Ticket struct {
+	ID         string
+	Node       string
+	ClusterId  string
+	Status     string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	RedeemedAt *time.Time `json:",omitempty"`
+}
+
+type ClusterServers struct {
+	Expect  int
+	Servers []string
+}
+
+type ClusterService struct {
+	NodeName      string
+	ClusterId     string
+	Datacenter    string
+	Role          string
+	Address       string
+	ConsulVersion string
+	HttpPort      int
+	HttpsPort     int
+	RpcPort       int
+	SerfPort      int
+}
+
+type FederationConfig struct {
+	PrimaryDatacenter string
+	WanAddresses      []string
+	ReplicationToken  string
+}
+
+// OperatorSettings is the -operator-config file. Unset fields keep the current cluster value.
+type OperatorSettings struct {
+	Autopilot *AutopilotSettings `json:"autopilot"`
+	Raft      *RaftSettings      `json:"raft"`
+}
+
+type AutopilotSettings struct {
+	CleanupDeadServers      *bool   `json:"cleanup_dead_servers"`
+	LastContactThreshold    *string `json:"last_contact_threshold"`
+	MaxTrailingLogs         *uint64 `json:"max_trailing_logs"`
+	MinQuorum               *uint   `json:"min_quorum"`
+	ServerStabilizationTime *string `json:"server_stabilization_time"`
+}
+
+type RaftSettings struct {
+	RaftMultiplier *int `json:"raft_multiplier"`
+}
+
+// ReapedNode is the audit record written for every node removed by the reaper.
+type ReapedNode struct {
+	Node           string
+	ClusterId      string
+	Address        string
+	CriticalSince  time.Time
+	ReapedAt       time.Time
+	Decommissioned bool
+	Errors         []string `json:",omitempty"`
+}
+
+// Profile holds the agent settings of a named profile that are not generated elsewhere.
+// Overrides use the same keys, so unknown keys are rejected when they are applied.
+type Profile struct {
+	Name          string                  `json:"-"`
+	Role          string                  `json:"-"`
+	Datacenter    string                  `json:"datacenter,omitempty"`
+	DataDir       string                  `json:"data_dir,omitempty"`
+	LogLevel      string                  `json:"log_level,omitempty"`
+	BindAddr      string                  `json:"bind_addr,omitempty"`
+	AdvertiseAddr string                  `json:"advertise_addr,omitempty"`
+	ClientAddr    string                  `json:"client_addr,omitempty"`
+	Ports         *config.PortsConfig     `json:"ports,omitempty"`
+	UIConfig      *config.UIConfig        `json:"ui_config,omitempty"`
+	Telemetry     *config.TelemetryConfig `json:"telemetry,omitempty"`
+}
+
+// ProfileOverrides is the -profile-file. Set applies to every node, Nodes to the named node only.
+type ProfileOverrides struct {
+	Set   json.RawMessage            `json:"set"`
+	Nodes map[string]json.RawMessage `json:"nodes"`
 }
@@ -0,0 +1,149 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// WaitCondition is a readiness check. Check returns a short progress description and whether the condition is met,
+// or an error if the condition can never be met.
+type WaitCondition struct {
+	Name  string
+	Check func() (string, bool, error)
+}
+
+func LeaderCondition(client *consul.ConsulClient) *WaitCondition {
+	return &WaitCondition{Name: "leader", Check: func() (string, bool, error) {
+		leader, err := consul.GetLeader(client)
+		if err != nil {
+			return err.Error(), false, nil
+		}
+		return leader, true, nil
+	}}
+}
+
+func RaftServersCondition(client *consul.ConsulClient, count int) *WaitCondition {
+	return &WaitCondition{Name: "servers", Check: func() (string, bool, error) {
+		servers, err := consul.GetRaftServers(client)
+		if err != nil {
+			return err.Error(), false, nil
+		}
+
+		voters := 0
+		for _, server := range servers {
+			if server.Voter {
+				voters++
+			}
+		}
+		return fmt.Sprintf("%d of %d voting servers", voters, count), voters >= count, nil
+	}}
+}
+
+func AclCondition(client *consul.ConsulClient) *WaitCondition {
+	return &WaitCondition{Name: "acl", Check: func() (string, bool, error) {
+		err := consul.AclReady(client)
+		if err == consul.ErrAclDisabled {
+			return err.Error(), false, err
+		}
+		if err != nil {
+			return err.Error(), false, nil
+		}
+		return "ready", true, nil
+	}}
+}
+
+func NodeRegisteredCondition(zeroConfClient *consul.ConsulClient, nodeName string) *WaitCondition {
+	return &WaitCondition{Name: "node", Check: func() (string, bool, error) {
+		service, err := consul.GetServiceInstance(zeroConfClient, CLUSTER_SERVICE, SanitizeNodeName(nodeName))
+		if err != nil {
+			return err.Error(), false, nil
+		}
+		if service == nil {
+			return nodeName + " not registered", false, nil
+		}
+		return nodeName + " registered at " + service.ServiceAddress, true, nil
+	}}
+}
+
+// ParseWaitConditions splits a -wait-for value such as "leader,servers=3,acl,node=web1" into names and arguments.
+func ParseWaitConditions(spec string) (map[string]string, error) {
+	conditions := map[string]string{}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, value := item, ""
+		if index := strings.Index(item, "="); index >= 0 {
+			name, value = item[:index], item[index+1:]
+		}
+
+		switch name {
+		case "leader", "acl":
+			if value != "" {
+				return nil, fmt.Errorf("%s does not take a value", name)
+			}
+		case "servers":
+			if count, err := strconv.Atoi(value); err != nil || count < 1 {
+				return nil, errors.New("servers requires a count, e.g. servers=3")
+			}
+		case "node":
+			if value == "" {
+				return nil, errors.New("node requires a node name, e.g. node=web1")
+			}
+		default:
+			return nil, fmt.Errorf("unknown condition %q", name)
+		}
+
+		conditions[name] = value
+	}
+
+	if len(conditions) == 0 {
+		return nil, errors.New("no conditions given")
+	}
+
+	return conditions, nil
+}
+
+// WaitFor polls the conditions every interval and logs their progress until all are met.
+// Returns an error naming the unmet conditions once timeout passes, or as soon as a condition can never be met.
+func WaitFor(conditions []*WaitCondition, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var pending, progress []string
+		for _, condition := range conditions {
+			detail, met, err := condition.Check()
+			if err != nil {
+				return fmt.Errorf("%s can never be met: %s", condition.Name, err)
+			}
+
+			state := "ok"
+			if !met {
+				state = "waiting"
+				pending = append(pending, condition.Name+" ("+detail+")")
+			}
+			progress = append(progress, fmt.Sprintf("%s: %s, %s", condition.Name, state, detail))
+		}
+
+		log.Printf("==> %s", strings.Join(progress, "; "))
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s", timeout, strings.Join(pending, ", "))
+		}
+
+		time.Sleep(interval)
+	}
+}
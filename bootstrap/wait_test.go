@@ -0,0 +1,105 @@
+package bootstrap
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWaitConditions(t *testing.T) {
+	cases := []struct {
+		spec string
+		want map[string]string
+	}{
+		{"leader", map[string]string{"leader": ""}},
+		{"leader,servers=3,acl,node=web1", map[string]string{"leader": "", "servers": "3", "acl": "", "node": "web1"}},
+		{" leader , servers=5 ,", map[string]string{"leader": "", "servers": "5"}},
+		{"node=web.1", map[string]string{"node": "web.1"}},
+		{"servers=1,servers=3", map[string]string{"servers": "3"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.spec, func(t *testing.T) {
+			got, err := ParseWaitConditions(c.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseWaitConditionsErrors(t *testing.T) {
+	cases := []struct {
+		spec string
+		err  string
+	}{
+		{"", "no conditions"},
+		{" , ", "no conditions"},
+		{"leader=yes", "does not take a value"},
+		{"acl=1", "does not take a value"},
+		{"servers", "requires a count"},
+		{"servers=0", "requires a count"},
+		{"servers=three", "requires a count"},
+		{"node", "requires a node name"},
+		{"node=", "requires a node name"},
+		{"quorum", "unknown condition"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.spec, func(t *testing.T) {
+			_, err := ParseWaitConditions(c.spec)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got error %v, want one containing %q", err, c.err)
+			}
+		})
+	}
+}
+
+// countdown is met once it has been checked calls times.
+func countdown(name string, calls int) *WaitCondition {
+	return &WaitCondition{Name: name, Check: func() (string, bool, error) {
+		calls--
+		return "checking", calls <= 0, nil
+	}}
+}
+
+func TestWaitFor(t *testing.T) {
+	disabled := &WaitCondition{Name: "acl", Check: func() (string, bool, error) {
+		return "disabled", false, errors.New("ACL support is disabled")
+	}}
+
+	cases := []struct {
+		name       string
+		conditions []*WaitCondition
+		timeout    time.Duration
+		err        string
+	}{
+		{"met at once", []*WaitCondition{countdown("leader", 1)}, time.Second, ""},
+		{"met after polling", []*WaitCondition{countdown("leader", 1), countdown("servers", 3)}, time.Second, ""},
+		{"timeout", []*WaitCondition{countdown("leader", 1), countdown("servers", 1000)}, 20 * time.Millisecond, "waiting for servers"},
+		{"never met", []*WaitCondition{countdown("leader", 1000), disabled}, time.Hour, "acl can never be met"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := WaitFor(c.conditions, c.timeout, time.Millisecond)
+
+			if c.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got error %v, want one containing %q", err, c.err)
+			}
+		})
+	}
+}
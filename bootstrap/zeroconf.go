@@ -0,0 +1,87 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"redserenity.com/consul-bootstrap/config"
+)
+
+const ZEROCONF_PROFILE_VERSION = 1
+
+// SERVER_CLUSTER_ID is the cluster ID the ZeroConf Server keeps its own secrets under, so they are never
+// shared with a managed cluster. -cluster-id refuses it.
+const SERVER_CLUSTER_ID = "_zeroconf-server"
+
+func SaveZeroConfProfile(profile *ZeroConf, path, file string) {
+	log.Printf("==> Saving ZeroConf client profile in %s%s.", path, file)
+
+	profile.Version = ZEROCONF_PROFILE_VERSION
+	content, err := json.MarshalIndent(profile, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := config.SaveConfig(path, file, string(content)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func LoadZeroConfProfile(file string) *ZeroConf {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	profile, err := ParseZeroConfProfile(content)
+	if err != nil {
+		log.Fatalf("==> Invalid ZeroConf client profile %s: %s", file, err)
+	}
+
+	return profile
+}
+
+// ParseZeroConfProfile decodes a zeroconf.json client profile and refuses versions newer than this version of ZeroConf reads.
+func ParseZeroConfProfile(content []byte) (*ZeroConf, error) {
+	profile := &ZeroConf{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return nil, err
+	}
+
+	if profile.Version > ZEROCONF_PROFILE_VERSION {
+		return nil, fmt.Errorf("version %d profile, this version of ZeroConf reads up to version %d", profile.Version, ZEROCONF_PROFILE_VERSION)
+	}
+
+	return profile, nil
+}
+
+// RedeemedTicketToken returns the token an earlier run redeemed from a join ticket for nodeName and kept in
+// path+file, or "" when there is none. Tickets are single-use, so a restarted node must use the kept token.
+func RedeemedTicketToken(path, file, nodeName string) string {
+	if _, err := os.Stat(path + file); os.IsNotExist(err) {
+		return ""
+	}
+
+	profile := LoadZeroConfProfile(path + file)
+	if profile.Node != nodeName {
+		return ""
+	}
+
+	return profile.Token
+}
+
+// WriteZeroConfProfile writes profile to w in the zeroconf.json format.
+func WriteZeroConfProfile(w io.Writer, profile *ZeroConf) error {
+	profile.Version = ZEROCONF_PROFILE_VERSION
+	content, err := json.MarshalIndent(profile, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(content))
+	return err
+}
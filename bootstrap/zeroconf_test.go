@@ -0,0 +1,112 @@
+package bootstrap
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseZeroConfProfile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    *ZeroConf
+	}{
+		{"legacy", `{"Address": "http://server.consul:8500", "Token": "token"}`,
+			&ZeroConf{Address: "http://server.consul:8500", Token: "token"}},
+		{"current", `{"Version": 1, "Address": "https://server.consul:8501", "Token": "token", "CaFingerprint": "ab:cd", "ClusterId": "web"}`,
+			&ZeroConf{Version: 1, Address: "https://server.consul:8501", Token: "token", CaFingerprint: "ab:cd", ClusterId: "web"}},
+		{"datacenter", `{"Version": 1, "Address": "http://server.consul:8500", "Token": "token", "Datacenter": "eu1"}`,
+			&ZeroConf{Version: 1, Address: "http://server.consul:8500", Token: "token", Datacenter: "eu1"}},
+		{"unknown fields", `{"Version": 1, "Address": "http://server.consul:8500", "Token": "token", "Comment": "web nodes"}`,
+			&ZeroConf{Version: 1, Address: "http://server.consul:8500", Token: "token"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseZeroConfProfile([]byte(c.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseZeroConfProfileErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"newer version", `{"Version": 2, "Address": "http://server.consul:8500"}`, "version 2 profile"},
+		{"not json", `Address = "http://server.consul:8500"`, "invalid character"},
+		{"wrong type", `{"Version": "1"}`, "Version"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseZeroConfProfile([]byte(c.content))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got error %v, want one containing %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestWriteZeroConfProfileRoundTrip(t *testing.T) {
+	profile := &ZeroConf{Address: "https://server.consul:8501", Token: "token", CaFingerprint: "ab:cd", ClusterId: "web"}
+
+	var buffer bytes.Buffer
+	if err := WriteZeroConfProfile(&buffer, profile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ZeroConf Server's own profile leaves the datacenter out, so nodes keep the one of their cluster.
+	if strings.Contains(buffer.String(), "Datacenter") {
+		t.Fatalf("profile without a datacenter writes one:\n%s", buffer.String())
+	}
+
+	parsed, err := ParseZeroConfProfile(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (&ZeroConf{Version: ZEROCONF_PROFILE_VERSION, Address: profile.Address, Token: "token", CaFingerprint: "ab:cd", ClusterId: "web"}); !reflect.DeepEqual(parsed, want) {
+		t.Fatalf("got %+v, want %+v", parsed, want)
+	}
+}
+
+func TestRedeemedTicketToken(t *testing.T) {
+	path := t.TempDir() + "/"
+
+	if token := RedeemedTicketToken(path, "zeroconf.json", "web-1"); token != "" {
+		t.Fatalf("got token %q without a profile", token)
+	}
+
+	SaveZeroConfProfile(&ZeroConf{Address: "http://server.consul:8500", Token: "node-token", Node: "web-1"}, path, "zeroconf.json")
+
+	cases := []struct {
+		node string
+		want string
+	}{
+		{"web-1", "node-token"},
+		{"web-2", ""},
+	}
+
+	for _, c := range cases {
+		if token := RedeemedTicketToken(path, "zeroconf.json", c.node); token != c.want {
+			t.Errorf("RedeemedTicketToken(%s) = %q, want %q", c.node, token, c.want)
+		}
+	}
+
+	// A profile from -bootstrap-server holds the shared registration token, which no ticket was redeemed for.
+	SaveZeroConfProfile(&ZeroConf{Address: "http://server.consul:8500", Token: "registration-token"}, path, "zeroconf.json")
+	if token := RedeemedTicketToken(path, "zeroconf.json", "web-1"); token != "" {
+		t.Fatalf("got token %q from a profile without a node", token)
+	}
+}
@@ -0,0 +1,194 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+type Certificate struct {
+	CertPEM string
+	KeyPEM  string
+}
+
+type CertRequest struct {
+	CommonName  string
+	DNSNames    []string
+	IPAddresses []net.IP
+	Validity    time.Duration
+}
+
+func CreateCA(commonName string, validity time.Duration, clock Clock) (*Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"Consul ZeroConf"}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(der, key)
+}
+
+// IssueCert signs a new agent certificate with the given CA. The certificate never outlives the CA, so it is
+// valid for less than request.Validity once the CA is that close to expiring. An expired CA is refused.
+// Every certificate can be used for both server and client auth, as client agents serve HTTPS too.
+func IssueCert(ca *Certificate, request *CertRequest, clock Clock) (*Certificate, error) {
+	caCert, err := ParseCert(ca.CertPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	if TimeUntilExpiry(caCert, clock) <= 0 {
+		return nil, fmt.Errorf("the CA %s expired on %s", caCert.Subject.CommonName, caCert.NotAfter.Format(time.RFC3339))
+	}
+
+	caKey, err := parseKey(ca.KeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	notAfter := now.Add(request.Validity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: request.CommonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     notAfter,
+		DNSNames:     request.DNSNames,
+		IPAddresses:  request.IPAddresses,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(der, key)
+}
+
+func ParseCert(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate found in PEM data")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Fingerprint returns the hex encoded SHA-256 fingerprint of a PEM certificate.
+func Fingerprint(certPEM string) (string, error) {
+	cert, err := ParseCert(certPEM)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func parseKey(keyPEM string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.New("no EC private key found in PEM data")
+	}
+
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) (*Certificate, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{
+		CertPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		KeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+	}, nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Clock allows expiry calculations to be tested against a simulated time.
+type Clock interface {
+	Now() time.Time
+}
+
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// TimeUntilExpiry returns how long the certificate stays valid, negative once it has expired.
+func TimeUntilExpiry(cert *x509.Certificate, clock Clock) time.Duration {
+	return cert.NotAfter.Sub(clock.Now())
+}
+
+func DaysUntilExpiry(cert *x509.Certificate, clock Clock) int {
+	return int(TimeUntilExpiry(cert, clock).Hours() / 24)
+}
+
+func NeedsRenewal(cert *x509.Certificate, clock Clock, threshold time.Duration) bool {
+	return TimeUntilExpiry(cert, clock) <= threshold
+}
+
+// CanRenew reports whether a certificate issued by ca now would be valid for longer than threshold.
+// Certificates never outlive their CA, so once the CA itself needs renewal every issued certificate
+// would be due for renewal straight away.
+func CanRenew(ca *x509.Certificate, clock Clock, threshold time.Duration) bool {
+	return !NeedsRenewal(ca, clock, threshold)
+}
+
+// IsServerCert reports whether cert was issued to a server agent, whose certificates are named server.<dc>.consul.
+func IsServerCert(cert *x509.Certificate) bool {
+	return strings.HasPrefix(cert.Subject.CommonName, "server.")
+}
@@ -0,0 +1,271 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+const day = 24 * time.Hour
+
+func newClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func mustCA(t *testing.T, clock Clock, validity time.Duration) *Certificate {
+	t.Helper()
+
+	ca, err := CreateCA("Test CA", validity, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ca
+}
+
+func mustParse(t *testing.T, certPEM string) *x509.Certificate {
+	t.Helper()
+
+	cert, err := ParseCert(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestIssueCertValidity(t *testing.T) {
+	cases := []struct {
+		name     string
+		caAge    time.Duration
+		validity time.Duration
+		want     time.Duration
+	}{
+		{"within CA validity", 0, 30 * day, 30 * day},
+		{"capped at CA expiry", 0, 400 * day, 365 * day},
+		{"CA close to expiry", 350 * day, 30 * day, 15 * day},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clock := newClock()
+			ca := mustCA(t, clock, 365*day)
+			clock.Advance(c.caAge)
+
+			issued, err := IssueCert(ca, &CertRequest{CommonName: "server.dc1.consul", Validity: c.validity}, clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cert := mustParse(t, issued.CertPEM)
+			if got := TimeUntilExpiry(cert, clock); got != c.want {
+				t.Fatalf("certificate valid for %s, want %s", got, c.want)
+			}
+
+			if !cert.NotBefore.Before(clock.Now()) {
+				t.Fatalf("certificate not valid yet: NotBefore %s", cert.NotBefore)
+			}
+		})
+	}
+}
+
+func TestIssueCertChainsToCA(t *testing.T) {
+	clock := newClock()
+	ca := mustCA(t, clock, 365*day)
+
+	issued, err := IssueCert(ca, &CertRequest{
+		CommonName:  "server.dc1.consul",
+		DNSNames:    []string{"server.dc1.consul", "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		Validity:    30 * day,
+	}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(mustParse(t, ca.CertPEM))
+
+	_, err = mustParse(t, issued.CertPEM).Verify(x509.VerifyOptions{
+		DNSName:     "server.dc1.consul",
+		Roots:       roots,
+		CurrentTime: clock.Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIssueCertRefusesExpiredCA(t *testing.T) {
+	clock := newClock()
+	ca := mustCA(t, clock, 30*day)
+	clock.Advance(31 * day)
+
+	if _, err := IssueCert(ca, &CertRequest{CommonName: "client.dc1.consul", Validity: day}, clock); err == nil {
+		t.Fatal("expected an error for an expired CA")
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	clock := newClock()
+	ca := mustCA(t, clock, 365*day)
+
+	issued, err := IssueCert(ca, &CertRequest{CommonName: "client.dc1.consul", Validity: 90 * day}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := mustParse(t, issued.CertPEM)
+
+	cases := []struct {
+		elapsed time.Duration
+		days    int
+		renew   bool
+	}{
+		{0, 90, false},
+		{59 * day, 31, false},
+		{60 * day, 30, true},
+		{89 * day, 1, true},
+		{91 * day, -1, true},
+	}
+
+	for _, c := range cases {
+		at := &fakeClock{now: clock.Now().Add(c.elapsed)}
+
+		if got := DaysUntilExpiry(cert, at); got != c.days {
+			t.Errorf("after %s: %d days until expiry, want %d", c.elapsed, got, c.days)
+		}
+
+		if got := NeedsRenewal(cert, at, 30*day); got != c.renew {
+			t.Errorf("after %s: NeedsRenewal = %t, want %t", c.elapsed, got, c.renew)
+		}
+	}
+}
+
+// A certificate issued by a CA within the renewal window is due for renewal straight away,
+// so renewal must stop once CanRenew reports false.
+func TestRenewalNearCAExpiry(t *testing.T) {
+	threshold := 30 * day
+	clock := newClock()
+	ca := mustCA(t, clock, 365*day)
+	caCert := mustParse(t, ca.CertPEM)
+
+	cases := []struct {
+		caAge    time.Duration
+		canRenew bool
+	}{
+		{0, true},
+		{334 * day, true},
+		{335 * day, false},
+		{364 * day, false},
+	}
+
+	for _, c := range cases {
+		at := &fakeClock{now: clock.Now().Add(c.caAge)}
+
+		if got := CanRenew(caCert, at, threshold); got != c.canRenew {
+			t.Errorf("CA aged %s: CanRenew = %t, want %t", c.caAge, got, c.canRenew)
+		}
+
+		issued, err := IssueCert(ca, &CertRequest{CommonName: "client.dc1.consul", Validity: 90 * day}, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if renew := NeedsRenewal(mustParse(t, issued.CertPEM), at, threshold); renew == c.canRenew {
+			t.Errorf("CA aged %s: fresh certificate NeedsRenewal = %t", c.caAge, renew)
+		}
+	}
+}
+
+func TestIsServerCert(t *testing.T) {
+	clock := newClock()
+	ca := mustCA(t, clock, 365*day)
+
+	for name, server := range map[string]bool{"server.dc1.consul": true, "client.dc1.consul": false, "zeroconf-federation": false} {
+		issued, err := IssueCert(ca, &CertRequest{CommonName: name, Validity: day}, clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := IsServerCert(mustParse(t, issued.CertPEM)); got != server {
+			t.Errorf("IsServerCert(%s) = %t, want %t", name, got, server)
+		}
+	}
+}
+
+// Client agents serve HTTPS with their certificate too, so it must be accepted by TLS clients.
+func TestClientCertServesHTTPS(t *testing.T) {
+	ca := mustCA(t, SystemClock{}, 365*day)
+
+	issued, err := IssueCert(ca, &CertRequest{
+		CommonName:  "client.dc1.consul",
+		DNSNames:    []string{"client.dc1.consul", "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		Validity:    day,
+	}, SystemClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPair, err := tls.X509KeyPair([]byte(issued.CertPEM), []byte(issued.KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{keyPair}}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(mustParse(t, ca.CertPEM))
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "client.dc1.consul"}}}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+}
+
+func TestFingerprint(t *testing.T) {
+	ca := mustCA(t, newClock(), day)
+
+	first, err := Fingerprint(ca.CertPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) != 64 {
+		t.Fatalf("fingerprint %q is not a hex encoded SHA-256", first)
+	}
+
+	second, _ := Fingerprint(ca.CertPEM)
+	if first != second {
+		t.Fatal("fingerprint is not stable")
+	}
+
+	if _, err := Fingerprint("not a certificate"); err == nil {
+		t.Fatal("expected an error for invalid PEM")
+	}
+}
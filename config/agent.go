@@ -0,0 +1,234 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	FORMAT_HCL  = "hcl"
+	FORMAT_JSON = "json"
+)
+
+var configFormat = FORMAT_HCL
+
+// AgentConfig is the subset of the Consul agent configuration ZeroConf generates.
+// Every generated file sets only some of the fields, unset fields are left out of the file.
+type AgentConfig struct {
+	Datacenter           string             `json:"datacenter,omitempty"`
+	DataDir              string             `json:"data_dir,omitempty"`
+	LogLevel             string             `json:"log_level,omitempty"`
+	BindAddr             string             `json:"bind_addr,omitempty"`
+	AdvertiseAddr        string             `json:"advertise_addr,omitempty"`
+	ClientAddr           string             `json:"client_addr,omitempty"`
+	Server               *bool              `json:"server,omitempty"`
+	BootstrapExpect      int                `json:"bootstrap_expect,omitempty"`
+	PrimaryDatacenter    string             `json:"primary_datacenter,omitempty"`
+	Encrypt              string             `json:"encrypt,omitempty"`
+	RetryJoin            []string           `json:"retry_join,omitempty"`
+	RetryJoinWan         []string           `json:"retry_join_wan,omitempty"`
+	CAFile               string             `json:"ca_file,omitempty"`
+	CertFile             string             `json:"cert_file,omitempty"`
+	KeyFile              string             `json:"key_file,omitempty"`
+	VerifyIncomingRPC    *bool              `json:"verify_incoming_rpc,omitempty"`
+	VerifyOutgoing       *bool              `json:"verify_outgoing,omitempty"`
+	VerifyServerHostname *bool              `json:"verify_server_hostname,omitempty"`
+	Ports                *PortsConfig       `json:"ports,omitempty"`
+	UIConfig             *UIConfig          `json:"ui_config,omitempty"`
+	Connect              *ConnectConfig     `json:"connect,omitempty"`
+	ACL                  *ACLConfig         `json:"acl,omitempty"`
+	AutoConfig           *AutoConfig        `json:"auto_config,omitempty"`
+	Performance          *PerformanceConfig `json:"performance,omitempty"`
+	Telemetry            *TelemetryConfig   `json:"telemetry,omitempty"`
+}
+
+type PortsConfig struct {
+	DNS     int `json:"dns,omitempty"`
+	HTTP    int `json:"http,omitempty"`
+	HTTPS   int `json:"https,omitempty"`
+	GRPC    int `json:"grpc,omitempty"`
+	SerfLAN int `json:"serf_lan,omitempty"`
+	Server  int `json:"server,omitempty"`
+}
+
+type UIConfig struct {
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type ConnectConfig struct {
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type ACLConfig struct {
+	Enabled                *bool            `json:"enabled,omitempty"`
+	DefaultPolicy          string           `json:"default_policy,omitempty"`
+	EnableTokenPersistence *bool            `json:"enable_token_persistence,omitempty"`
+	EnableTokenReplication *bool            `json:"enable_token_replication,omitempty"`
+	Tokens                 *ACLTokensConfig `json:"tokens,omitempty"`
+}
+
+type ACLTokensConfig struct {
+	Agent       string `json:"agent,omitempty"`
+	Replication string `json:"replication,omitempty"`
+}
+
+type AutoConfig struct {
+	Enabled         *bool                    `json:"enabled,omitempty"`
+	IntroToken      string                   `json:"intro_token,omitempty"`
+	ServerAddresses []string                 `json:"server_addresses,omitempty"`
+	Authorization   *AutoConfigAuthorization `json:"authorization,omitempty"`
+}
+
+type AutoConfigAuthorization struct {
+	Enabled *bool                 `json:"enabled,omitempty"`
+	Static  *AutoConfigAuthorizer `json:"static,omitempty"`
+}
+
+type AutoConfigAuthorizer struct {
+	JWTValidationPubKeys []string          `json:"jwt_validation_pub_keys,omitempty"`
+	BoundIssuer          string            `json:"bound_issuer,omitempty"`
+	BoundAudiences       []string          `json:"bound_audiences,omitempty"`
+	ClaimMappings        map[string]string `json:"claim_mappings,omitempty"`
+	ClaimAssertions      []string          `json:"claim_assertions,omitempty"`
+}
+
+type PerformanceConfig struct {
+	RaftMultiplier int `json:"raft_multiplier,omitempty"`
+}
+
+type TelemetryConfig struct {
+	DisableHostname         *bool  `json:"disable_hostname,omitempty"`
+	PrometheusRetentionTime string `json:"prometheus_retention_time,omitempty"`
+	StatsdAddress           string `json:"statsd_address,omitempty"`
+	DogstatsdAddr           string `json:"dogstatsd_addr,omitempty"`
+}
+
+func Bool(value bool) *bool {
+	return &value
+}
+
+// SetFormat selects whether agent config files are written as HCL or JSON.
+func SetFormat(format string) error {
+	if format != FORMAT_HCL && format != FORMAT_JSON {
+		return errors.New("config format must be hcl or json")
+	}
+
+	configFormat = format
+	return nil
+}
+
+// AgentConfigFile returns the file name of the name agent config file in the selected format.
+func AgentConfigFile(name string) string {
+	return name + "." + configFormat
+}
+
+func otherFormatFile(name string) string {
+	if configFormat == FORMAT_JSON {
+		return name + "." + FORMAT_HCL
+	}
+	return name + "." + FORMAT_JSON
+}
+
+// EncodeAgentConfig renders agentConfig in the selected format. Values are always escaped by the encoder,
+// so tokens and names can never change the structure of the file, and the result is parsed back to make sure.
+func EncodeAgentConfig(agentConfig *AgentConfig) (string, error) {
+	values, err := agentConfigValues(agentConfig)
+	if err != nil {
+		return "", err
+	}
+
+	contents, err := encodeValues(values)
+	if err != nil {
+		return "", err
+	}
+
+	return contents, VerifyEncoding("config", contents, values)
+}
+
+func encodeValues(values map[string]interface{}) (string, error) {
+	if configFormat == FORMAT_JSON {
+		content, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(content) + "\n", nil
+	}
+
+	return EncodeHCL(values), nil
+}
+
+// SaveAgentConfig writes agentConfig to the name file in the selected format and removes the file of the other format.
+// Returns false when the file already had the same content.
+// The rendered file is parsed back before it is written, so a file the agent cannot load is never written.
+func SaveAgentConfig(path, name string, agentConfig *AgentConfig, mode os.FileMode) (bool, error) {
+	contents, err := EncodeAgentConfig(agentConfig)
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", AgentConfigFile(name), err)
+	}
+
+	if existing, err := ioutil.ReadFile(path + AgentConfigFile(name)); err == nil && string(existing) == contents {
+		return false, removeFile(path + otherFormatFile(name))
+	}
+
+	values, _ := agentConfigValues(agentConfig)
+	previous := readValues(path, AgentConfigFile(name), otherFormatFile(name))
+
+	if err := SaveConfigAtomic(path, AgentConfigFile(name), contents, mode); err != nil {
+		return false, err
+	}
+	recordChanges(previous, values)
+
+	return true, removeFile(path + otherFormatFile(name))
+}
+
+// RemoveAgentConfig removes the name file in either format.
+func RemoveAgentConfig(path, name string) error {
+	for _, format := range []string{FORMAT_HCL, FORMAT_JSON} {
+		previous := readValues(path, name+"."+format)
+
+		if err := removeFile(path + name + "." + format); err != nil {
+			return err
+		}
+		recordChanges(previous, nil)
+	}
+
+	return nil
+}
+
+func DefaultMode() os.FileMode {
+	return defaultMode
+}
+
+// agentConfigValues converts agentConfig into the generic form ParseConfig returns.
+func agentConfigValues(agentConfig *AgentConfig) (map[string]interface{}, error) {
+	content, err := json.Marshal(agentConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	values := map[string]interface{}{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+
+	return normalize(values).(map[string]interface{}), nil
+}
+
+func removeFile(name string) error {
+	if _, err := os.Stat(name); err == nil {
+		recordWrite(name)
+	}
+
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeHCL(t *testing.T) {
+	cases := []struct {
+		name   string
+		values map[string]interface{}
+		want   string
+	}{
+		{"empty", map[string]interface{}{}, ""},
+		{"sorted keys", map[string]interface{}{"server": true, "datacenter": "dc1", "bootstrap_expect": 3},
+			"bootstrap_expect = 3\ndatacenter = \"dc1\"\nserver = true\n"},
+		{"escaped string", map[string]interface{}{"encrypt": "a\"b\\c\n}"}, "encrypt = \"a\\\"b\\\\c\\n}\"\n"},
+		{"list", map[string]interface{}{"retry_join": []interface{}{"10.0.0.1", "10.0.0.2"}},
+			"retry_join = [\"10.0.0.1\", \"10.0.0.2\"]\n"},
+		{"nested block", map[string]interface{}{"acl": map[string]interface{}{"enabled": true, "tokens": map[string]interface{}{"agent": "token"}}},
+			"acl {\n  enabled = true\n  tokens {\n    agent = \"token\"\n  }\n}\n"},
+		{"repeated block", map[string]interface{}{"service": []map[string]interface{}{{"name": "a"}, {"name": "b"}}},
+			"service {\n  name = \"a\"\n}\nservice {\n  name = \"b\"\n}\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := EncodeHCL(c.values)
+			if got != c.want {
+				t.Fatalf("got\n%s\nwant\n%s", got, c.want)
+			}
+
+			if err := VerifyEncoding("test.hcl", got, c.values); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEncodeAgentConfigRoundTrip(t *testing.T) {
+	agentConfig := &AgentConfig{
+		Datacenter:      "dc1",
+		Server:          Bool(false),
+		BootstrapExpect: 3,
+		Encrypt:         "key\" } acl { enabled = false",
+		RetryJoin:       []string{"10.0.0.1"},
+		Ports:           &PortsConfig{HTTPS: 8501},
+		ACL:             &ACLConfig{Enabled: Bool(true), Tokens: &ACLTokensConfig{Agent: "token"}},
+		Telemetry:       &TelemetryConfig{StatsdAddress: "127.0.0.1:8125"},
+	}
+
+	values, err := agentConfigValues(agentConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FORMAT_HCL, FORMAT_JSON} {
+		t.Run(format, func(t *testing.T) {
+			if err := SetFormat(format); err != nil {
+				t.Fatal(err)
+			}
+			defer SetFormat(FORMAT_HCL)
+
+			contents, err := EncodeAgentConfig(agentConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := ParseConfig(contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(parsed, values) {
+				t.Fatalf("%s decodes to %v, want %v", format, parsed, values)
+			}
+
+			if parsed["encrypt"] != agentConfig.Encrypt {
+				t.Fatalf("encrypt decodes to %q", parsed["encrypt"])
+			}
+		})
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	defer SetFormat(FORMAT_HCL)
+
+	if err := SetFormat("yaml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	if err := SetFormat(FORMAT_JSON); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := AgentConfigFile("acl"); got != "acl.json" {
+		t.Fatalf("AgentConfigFile = %q, want acl.json", got)
+	}
+
+	if got := otherFormatFile("acl"); got != "acl.hcl" {
+		t.Fatalf("otherFormatFile = %q, want acl.hcl", got)
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// changes maps every key changed by the agent config written since the last TakeChanges to its new value.
+// Keys are dotted paths, e.g. acl.tokens.agent, removed keys map to nil.
+var changes = map[string]interface{}{}
+
+// TakeChanges returns the keys changed since the last call and starts a new set.
+func TakeChanges() map[string]interface{} {
+	taken := changes
+	changes = map[string]interface{}{}
+	return taken
+}
+
+// originalFile is a file as it was before the first write since the last TakeWrites, contents is nil when
+// the file did not exist.
+type originalFile struct {
+	contents []byte
+	mode     os.FileMode
+}
+
+// written maps every file written or removed since the last TakeWrites to its original state.
+var written = map[string]*originalFile{}
+
+// TakeWrites returns the files written or removed since the last call and starts a new set, the written
+// files are kept as they are.
+func TakeWrites() []string {
+	names := writtenNames()
+	written = map[string]*originalFile{}
+	return names
+}
+
+// RestoreWrites puts every file written or removed since the last TakeWrites back the way it was and
+// returns the restored files.
+func RestoreWrites() ([]string, error) {
+	names := writtenNames()
+
+	for _, name := range names {
+		original := written[name]
+		if original.contents == nil {
+			if err := removeFile(name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		path, file := filepath.Split(name)
+		if err := SaveConfigAtomic(path, file, string(original.contents), original.mode); err != nil {
+			return nil, err
+		}
+	}
+
+	written = map[string]*originalFile{}
+	return names, nil
+}
+
+// recordWrite keeps the original state of name the first time it is written or removed.
+func recordWrite(name string) {
+	if _, ok := written[name]; ok {
+		return
+	}
+
+	original := &originalFile{}
+	if info, err := os.Stat(name); err == nil {
+		if contents, err := ioutil.ReadFile(name); err == nil {
+			original.contents, original.mode = contents, info.Mode().Perm()
+		}
+	}
+
+	written[name] = original
+}
+
+func writtenNames() []string {
+	names := make([]string, 0, len(written))
+	for name := range written {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func recordChanges(before, after map[string]interface{}) {
+	previous, current := flatten("", before), flatten("", after)
+
+	for key, value := range current {
+		if !reflect.DeepEqual(previous[key], value) {
+			changes[key] = value
+		}
+	}
+
+	// A key removed here may have moved to another file, e.g. server when switching roles, so it is only
+	// recorded as removed when no other file set it.
+	for key := range previous {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if _, ok := changes[key]; !ok {
+			changes[key] = nil
+		}
+	}
+}
+
+// readValues parses the first of files in path that exists. Files that are missing or do not parse count as empty.
+func readValues(path string, files ...string) map[string]interface{} {
+	for _, file := range files {
+		content, err := ioutil.ReadFile(path + file)
+		if err != nil {
+			continue
+		}
+
+		values, err := ParseConfig(string(content))
+		if err != nil {
+			break
+		}
+
+		return values
+	}
+
+	return map[string]interface{}{}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRestoreWrites(t *testing.T) {
+	path := tempConfigDir(t, map[string]string{"acl.hcl": existingAcl, "gossip.json": `{"encrypt": "key"}`})
+	if err := os.Chmod(path+"acl.hcl", 0640); err != nil {
+		t.Fatal(err)
+	}
+	TakeWrites()
+	useBackupTimes(t, time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC))
+
+	if err := MergeAgentConfig(path, "acl", aclWithAgentToken("new-token")); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveAgentConfig(path, "gossip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(path, "tls.hcl", `ca_file = "ca.pem"`); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := RestoreWrites()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{path + "acl.hcl", path + "acl.hcl.20240201T100000Z.bak", path + "gossip.json", path + "tls.hcl"}
+	if !reflect.DeepEqual(restored, want) {
+		t.Fatalf("restored %v, want %v", restored, want)
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	for _, file := range files {
+		content, _ := ioutil.ReadFile(path + file.Name())
+		contents[file.Name()] = string(content)
+
+		if file.Name() == "acl.hcl" && file.Mode().Perm() != 0640 {
+			t.Fatalf("acl.hcl mode is %s, want -rw-r-----", file.Mode().Perm())
+		}
+	}
+
+	if want := map[string]string{"acl.hcl": existingAcl, "gossip.json": `{"encrypt": "key"}`}; !reflect.DeepEqual(contents, want) {
+		t.Fatalf("config dir holds %v, want %v", contents, want)
+	}
+
+	if restored := TakeWrites(); len(restored) != 0 {
+		t.Fatalf("writes %v are still pending after the restore", restored)
+	}
+}
@@ -3,7 +3,10 @@ package config
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/user"
+	"strconv"
 	"text/template"
 )
 
@@ -23,17 +26,155 @@ func GetTemplate(name, tmplString string, vars interface{}) (string, error) {
 	return compiledTemplate.String(), nil
 }
 
+// Ownership and default mode applied to written files. -1 keeps the uid/gid of the running process.
+var (
+	fileUid       = -1
+	fileGid       = -1
+	defaultMode   = os.FileMode(0600)
+	directoryMode = os.FileMode(0750)
+)
+
+// SetDefaultMode changes the mode SaveConfig uses. Files written with an explicit mode are not affected.
+func SetDefaultMode(mode os.FileMode) {
+	defaultMode = mode
+}
+
+// SetOwner makes written files and created directories owned by owner and group, given as names or numeric IDs.
+// Empty values keep the current user or group.
+func SetOwner(owner, group string) error {
+	if owner != "" {
+		uid, err := lookupId(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		fileUid = uid
+	}
+
+	if group != "" {
+		gid, err := lookupId(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		fileGid = gid
+	}
+
+	return nil
+}
+
+func lookupId(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// SaveConfig atomically writes filename with the default mode, see SaveConfigAtomic.
 func SaveConfig(path, filename, contents string) error {
-	file, err := os.Create(path + filename)
+	return SaveConfigAtomic(path, filename, contents, defaultMode)
+}
+
+// SaveConfigAtomic writes contents to a temporary file in path and renames it over filename, so a crash
+// never leaves a truncated file behind. path is created when missing and mode is enforced even when the
+// file already exists.
+func SaveConfigAtomic(path, filename, contents string, mode os.FileMode) error {
+	if err := ensureDirectory(path); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(path, "."+filename+".tmp-")
 	if err != nil {
 		return err
 	}
 
-	defer file.Close()
+	tmpName := file.Name()
+	defer os.Remove(tmpName)
+
+	recordWrite(path + filename)
+
+	if err = file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = chown(file.Name()); err != nil {
+		file.Close()
+		return err
+	}
 
 	if _, err = io.WriteString(file, contents); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpName, path+filename); err != nil {
+		return err
+	}
+
+	return syncDirectory(path)
+}
+
+func ensureDirectory(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(path, directoryMode); err != nil {
+		return err
+	}
+
+	return chown(path)
+}
+
+func chown(name string) error {
+	if fileUid == -1 && fileGid == -1 {
+		return nil
+	}
+
+	return os.Chown(name, fileUid, fileGid)
+}
+
+// syncDirectory persists the rename itself.
+func syncDirectory(path string) error {
+	if path == "" {
+		path = "."
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
 		return err
 	}
+	defer dir.Close()
 
-	return file.Sync()
+	return dir.Sync()
 }
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveConfigAtomicReplacesFile(t *testing.T) {
+	dir := t.TempDir() + "/"
+	if err := ioutil.WriteFile(dir+"agent.hcl", []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader holding the old file keeps its complete contents, the new file is renamed over it.
+	original, err := os.Open(dir + "agent.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+
+	if err := SaveConfigAtomic(dir, "agent.hcl", "new", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, err := ioutil.ReadAll(original); err != nil || string(contents) != "old" {
+		t.Fatalf("old file reads %q (%v)", contents, err)
+	}
+
+	if contents, err := ioutil.ReadFile(dir + "agent.hcl"); err != nil || string(contents) != "new" {
+		t.Fatalf("new file reads %q (%v)", contents, err)
+	}
+
+	originalInfo, _ := original.Stat()
+	newInfo, err := os.Stat(dir + "agent.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(originalInfo, newInfo) {
+		t.Fatal("file was rewritten in place")
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func TestSaveConfigAtomicModes(t *testing.T) {
+	defer SetDefaultMode(defaultMode)
+	SetDefaultMode(0640)
+
+	dir := t.TempDir() + "/"
+	if err := ioutil.WriteFile(dir+"tls.hcl", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveConfig(dir, "agent.hcl", "config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfigAtomic(dir, "tls.hcl", "secret", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]os.FileMode{"agent.hcl": 0640, "tls.hcl": 0600} {
+		info, err := os.Stat(dir + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Fatalf("%s has mode %o, want %o", name, info.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestSaveConfigAtomicFailureKeepsOriginal(t *testing.T) {
+	dir := t.TempDir() + "/"
+
+	// The temporary file name is longer than the target name, so creating it fails.
+	name := strings.Repeat("a", 240) + ".hcl"
+	if err := ioutil.WriteFile(dir+name, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveConfigAtomic(dir, name, "new", 0600); err == nil {
+		t.Fatal("write with an invalid temporary file name succeeded")
+	}
+
+	if contents, err := ioutil.ReadFile(dir + name); err != nil || string(contents) != "old" {
+		t.Fatalf("original file reads %q (%v)", contents, err)
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	matches, err := filepath.Glob(dir + ".*.tmp-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,288 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+)
+
+// backupMode is used for backups whatever the default mode is, since they hold the same tokens and keys as the file.
+const backupMode = os.FileMode(0600)
+
+// backupTime timestamps backups, tests replace it.
+var backupTime = time.Now
+
+// BackupFile is the name of the backup MergeAgentConfig keeps of file when it changes it at now.
+func BackupFile(file string, now time.Time) string {
+	return file + "." + now.UTC().Format("20060102T150405Z") + ".bak"
+}
+
+// MergeAgentConfig merges agentConfig into the existing name agent config file, keeping every key it does not set.
+// A file of the other format is merged and replaced, so switching formats keeps operator additions. The previous
+// file is kept as <file>.<timestamp>.bak. It fails without writing anything when
+// another config file in path sets one of the generated keys to a different value, since Consul would silently use
+// only one of them. Files are written with the default mode.
+func MergeAgentConfig(path, name string, agentConfig *AgentConfig) error {
+	owned, err := agentConfigValues(agentConfig)
+	if err != nil {
+		return err
+	}
+
+	target, other := AgentConfigFile(name), otherFormatFile(name)
+
+	if err := CheckConflicts(path, []string{target, other}, owned); err != nil {
+		return err
+	}
+
+	merged := owned
+	source := target
+	existing, err := ioutil.ReadFile(path + target)
+	if os.IsNotExist(err) {
+		source = other
+		existing, err = ioutil.ReadFile(path + other)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	previous := map[string]interface{}{}
+	if err == nil {
+		current, err := ParseConfig(string(existing))
+		if err != nil {
+			return fmt.Errorf("unable to parse existing %s%s: %s", path, source, err)
+		}
+		// mergeMaps modifies current, so the previous values are kept from a second parse.
+		previous, _ = ParseConfig(string(existing))
+		merged = mergeMaps(current, owned)
+	}
+
+	contents, err := encodeValues(merged)
+	if err != nil {
+		return err
+	}
+
+	if err := VerifyEncoding(target, contents, merged); err != nil {
+		return err
+	}
+
+	if source == target && string(existing) == contents {
+		return nil
+	}
+
+	if len(existing) > 0 {
+		if err := SaveConfigAtomic(path, BackupFile(source, backupTime()), string(existing), backupMode); err != nil {
+			return err
+		}
+	}
+
+	if err := SaveConfigAtomic(path, target, contents, defaultMode); err != nil {
+		return err
+	}
+	recordChanges(previous, merged)
+
+	return removeFile(path + other)
+}
+
+// ParseConfig decodes HCL or JSON agent config. Blocks that appear once are returned as maps.
+func ParseConfig(contents string) (map[string]interface{}, error) {
+	var decoded map[string]interface{}
+	if err := hcl.Decode(&decoded, contents); err != nil {
+		return nil, err
+	}
+
+	if decoded == nil {
+		decoded = map[string]interface{}{}
+	}
+
+	return normalize(decoded).(map[string]interface{}), nil
+}
+
+// CheckConflicts returns an error if a config file in path, other than the skipped files, sets a key of owned to a different value.
+func CheckConflicts(path string, skip []string, owned map[string]interface{}) error {
+	ownedKeys := flatten("", owned)
+
+	for _, pattern := range []string{"*.hcl", "*.json"} {
+		files, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if isSkipped(filepath.Base(file), skip) {
+				continue
+			}
+
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+
+			other, err := ParseConfig(string(content))
+			if err != nil {
+				// Not every file in the directory has to be agent config.
+				continue
+			}
+
+			var conflicts []string
+			for key, value := range flatten("", other) {
+				if ownedValue, ok := ownedKeys[key]; ok && !reflect.DeepEqual(ownedValue, value) {
+					conflicts = append(conflicts, key)
+				}
+			}
+
+			if len(conflicts) > 0 {
+				sort.Strings(conflicts)
+				return fmt.Errorf("%s already sets %s, which %s%s needs to own. Remove it from %s first", file, strings.Join(conflicts, ", "), path, skip[0], filepath.Base(file))
+			}
+		}
+	}
+
+	return nil
+}
+
+func isSkipped(name string, skip []string) bool {
+	for _, skipped := range skip {
+		if name == skipped {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EncodeHCL renders decoded config as HCL with sorted keys.
+func EncodeHCL(values map[string]interface{}) string {
+	builder := &strings.Builder{}
+	writeHCL(builder, values, "")
+	return builder.String()
+}
+
+func writeHCL(builder *strings.Builder, values map[string]interface{}, indent string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch value := values[key].(type) {
+		case map[string]interface{}:
+			writeBlock(builder, key, value, indent)
+		case []map[string]interface{}:
+			for _, block := range value {
+				writeBlock(builder, key, block, indent)
+			}
+		default:
+			fmt.Fprintf(builder, "%s%s = %s\n", indent, key, encodeHCLValue(value))
+		}
+	}
+}
+
+func writeBlock(builder *strings.Builder, key string, block map[string]interface{}, indent string) {
+	fmt.Fprintf(builder, "%s%s {\n", indent, key)
+	writeHCL(builder, block, indent+"  ")
+	fmt.Fprintf(builder, "%s}\n", indent)
+}
+
+func encodeHCLValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strconv.Quote(value)
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = encodeHCLValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// normalize collapses the single element block lists the HCL decoder produces into maps
+// and turns JSON numbers into the int and float64 values the HCL decoder uses.
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if number, err := value.Int64(); err == nil {
+			return int(number)
+		}
+		number, _ := value.Float64()
+		return number
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = normalize(item)
+		}
+		return value
+	case []map[string]interface{}:
+		if len(value) == 1 {
+			return normalize(value[0])
+		}
+		for i, item := range value {
+			value[i] = normalize(item).(map[string]interface{})
+		}
+		return value
+	case []interface{}:
+		blocks := make([]map[string]interface{}, 0, len(value))
+		for i, item := range value {
+			block, ok := item.(map[string]interface{})
+			if !ok {
+				value[i] = normalize(item)
+				continue
+			}
+			blocks = append(blocks, block)
+		}
+		if len(blocks) != len(value) {
+			return value
+		}
+		if len(blocks) == 0 {
+			return value
+		}
+		return normalize(blocks)
+	default:
+		return value
+	}
+}
+
+// mergeMaps returns base with the keys of overlay applied, merging nested blocks.
+func mergeMaps(base, overlay map[string]interface{}) map[string]interface{} {
+	for key, value := range overlay {
+		overlayBlock, isBlock := value.(map[string]interface{})
+		baseBlock, baseIsBlock := base[key].(map[string]interface{})
+
+		if isBlock && baseIsBlock {
+			base[key] = mergeMaps(baseBlock, overlayBlock)
+		} else {
+			base[key] = value
+		}
+	}
+
+	return base
+}
+
+// flatten maps every leaf value to its dotted key path, e.g. acl.tokens.agent.
+func flatten(prefix string, values map[string]interface{}) map[string]interface{} {
+	leaves := map[string]interface{}{}
+
+	for key, value := range values {
+		if block, ok := value.(map[string]interface{}); ok {
+			for leaf, leafValue := range flatten(prefix+key+".", block) {
+				leaves[leaf] = leafValue
+			}
+			continue
+		}
+
+		leaves[prefix+key] = value
+	}
+
+	return leaves
+}
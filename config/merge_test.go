@@ -0,0 +1,209 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const existingAcl = `acl {
+  down_policy = "extend-cache"
+  enabled = true
+  tokens {
+    agent = "old-token"
+    default = "operator-token"
+  }
+}
+`
+
+func tempConfigDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	path := t.TempDir() + "/"
+	for name, content := range files {
+		if err := ioutil.WriteFile(path+name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+func readConfig(t *testing.T, file string) map[string]interface{} {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := ParseConfig(string(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return values
+}
+
+func aclWithAgentToken(token string) *AgentConfig {
+	return &AgentConfig{ACL: &ACLConfig{Enabled: Bool(true), Tokens: &ACLTokensConfig{Agent: token}}}
+}
+
+func TestMergeAgentConfigKeepsOperatorKeys(t *testing.T) {
+	path := tempConfigDir(t, map[string]string{"acl.hcl": existingAcl})
+
+	if err := MergeAgentConfig(path, "acl", aclWithAgentToken("new-token")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := flatten("", readConfig(t, path+"acl.hcl"))
+	want := map[string]interface{}{
+		"acl.down_policy":    "extend-cache",
+		"acl.enabled":        true,
+		"acl.tokens.agent":   "new-token",
+		"acl.tokens.default": "operator-token",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged config is %v, want %v", got, want)
+	}
+}
+
+// useBackupTimes makes the following merges take their backup timestamps from times, in order.
+func useBackupTimes(t *testing.T, times ...time.Time) {
+	t.Cleanup(func() { backupTime = time.Now })
+
+	backupTime = func() time.Time {
+		next := times[0]
+		times = times[1:]
+		return next
+	}
+}
+
+func TestMergeAgentConfigBackups(t *testing.T) {
+	path := tempConfigDir(t, map[string]string{
+		"acl.hcl":                      existingAcl,
+		"acl.hcl.20240101T000000Z.bak": existingAcl,
+	})
+	useBackupTimes(t, time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 11, 0, 0, 0, time.UTC))
+
+	for _, token := range []string{"first-token", "second-token", "second-token"} {
+		if err := MergeAgentConfig(path, "acl", aclWithAgentToken(token)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := filepath.Glob(path + "*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The unchanged third merge writes no backup.
+	want := []string{path + "acl.hcl.20240101T000000Z.bak", path + "acl.hcl.20240201T100000Z.bak", path + "acl.hcl.20240201T110000Z.bak"}
+	if !reflect.DeepEqual(backups, want) {
+		t.Fatalf("backups are %v, want %v", backups, want)
+	}
+
+	for backup, token := range map[string]string{"acl.hcl.20240201T100000Z.bak": "old-token", "acl.hcl.20240201T110000Z.bak": "first-token"} {
+		if got := flatten("", readConfig(t, path+backup))["acl.tokens.agent"]; got != token {
+			t.Fatalf("%s holds agent token %v, want %s", backup, got, token)
+		}
+
+		info, err := os.Stat(path + backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != backupMode {
+			t.Fatalf("%s mode is %s, want %s", backup, info.Mode().Perm(), backupMode)
+		}
+	}
+}
+
+func TestMergeAgentConfigReplacesOtherFormat(t *testing.T) {
+	path := tempConfigDir(t, map[string]string{"acl.json": `{"acl": {"down_policy": "allow"}}`})
+	useBackupTimes(t, time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC))
+
+	if err := MergeAgentConfig(path, "acl", aclWithAgentToken("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + "acl.json"); !os.IsNotExist(err) {
+		t.Fatal("acl.json was not removed")
+	}
+
+	if got := flatten("", readConfig(t, path+"acl.hcl"))["acl.down_policy"]; got != "allow" {
+		t.Fatalf("down_policy is %v, want the value kept from acl.json", got)
+	}
+
+	if _, err := os.Stat(path + "acl.json.20240201T100000Z.bak"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeAgentConfigConflict(t *testing.T) {
+	path := tempConfigDir(t, map[string]string{
+		"acl.hcl":    existingAcl,
+		"tokens.hcl": `acl { tokens { agent = "other-token" } }`,
+	})
+
+	err := MergeAgentConfig(path, "acl", aclWithAgentToken("new-token"))
+	if err == nil || !strings.Contains(err.Error(), "acl.tokens.agent") {
+		t.Fatalf("got error %v, want a conflict on acl.tokens.agent", err)
+	}
+
+	content, _ := ioutil.ReadFile(path + "acl.hcl")
+	if string(content) != existingAcl {
+		t.Fatal("acl.hcl was changed despite the conflict")
+	}
+
+	if backups, _ := filepath.Glob(path + "*.bak"); len(backups) != 0 {
+		t.Fatalf("backups %v were written despite the conflict", backups)
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	owned := map[string]interface{}{
+		"encrypt": "key",
+		"acl":     map[string]interface{}{"tokens": map[string]interface{}{"agent": "token"}},
+	}
+
+	cases := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{"no other files", nil, ""},
+		{"unrelated keys", map[string]string{"server.hcl": `server = true`}, ""},
+		{"same value", map[string]string{"extra.hcl": `encrypt = "key"`}, ""},
+		{"same value in json", map[string]string{"extra.json": `{"acl": {"tokens": {"agent": "token"}}}`}, ""},
+		{"skipped file", map[string]string{"gossip.json": `{"encrypt": "other"}`}, ""},
+		{"not agent config", map[string]string{"notes.hcl": `this is {{ not hcl`}, ""},
+		{"other extension", map[string]string{"gossip.conf": `encrypt = "other"`}, ""},
+		{"different value", map[string]string{"extra.hcl": `encrypt = "other"`}, "extra.hcl already sets encrypt"},
+		{"nested different value", map[string]string{"extra.json": `{"acl": {"tokens": {"agent": "other"}}}`}, "already sets acl.tokens.agent"},
+		{"every conflict listed", map[string]string{"extra.hcl": "encrypt = \"other\"\nacl { tokens { agent = \"other\" } }"}, "acl.tokens.agent, encrypt"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := tempConfigDir(t, c.files)
+
+			err := CheckConflicts(path, []string{"gossip.hcl", "gossip.json"}, owned)
+			if c.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got error %v, want one containing %q", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// agentConfigKeys are the top-level keys the Consul agent accepts in its config files.
+var agentConfigKeys = toSet(
+	"acl", "acl_agent_master_token", "acl_agent_token", "acl_datacenter", "acl_default_policy", "acl_down_policy",
+	"acl_enable_key_list_policy", "acl_master_token", "acl_replication_token", "acl_token", "acl_ttl",
+	"addresses", "advertise_addr", "advertise_addr_ipv4", "advertise_addr_ipv6", "advertise_addr_wan",
+	"advertise_addr_wan_ipv4", "advertise_addr_wan_ipv6", "advertise_reconnect_timeout", "alt_domain", "audit",
+	"auto_config", "auto_encrypt", "auto_reload_config", "autopilot", "bind_addr", "bootstrap", "bootstrap_expect",
+	"ca_file", "ca_path", "cert_file", "check", "check_output_max_size", "check_update_interval", "checks",
+	"client_addr", "cloud", "config_entries", "connect", "data_dir", "datacenter", "default_query_time",
+	"disable_anonymous_signature", "disable_coordinates", "disable_host_node_id", "disable_http_unprintable_char_filter",
+	"disable_keyring_file", "disable_remote_exec", "disable_update_check", "discard_check_output",
+	"discovery_max_stale", "dns_config", "domain", "enable_acl_replication", "enable_agent_tls_for_checks",
+	"enable_central_service_config", "enable_debug", "enable_local_script_checks", "enable_script_checks",
+	"enable_syslog", "encrypt", "encrypt_verify_incoming", "encrypt_verify_outgoing", "experiments", "gossip_lan",
+	"gossip_wan", "http_config", "key_file", "leave_on_terminate", "license_path", "limits", "locality", "log_file",
+	"log_json", "log_level", "log_rotate_bytes", "log_rotate_duration", "log_rotate_max_files", "max_query_time",
+	"node_id", "node_meta", "node_name", "non_voting_server", "peering", "performance", "pid_file", "ports",
+	"primary_datacenter", "primary_gateways", "primary_gateways_interval", "protocol", "raft_logstore",
+	"raft_protocol", "raft_snapshot_interval", "raft_snapshot_threshold", "raft_trailing_logs", "read_replica",
+	"reconnect_timeout", "reconnect_timeout_wan", "recursors", "rejoin_after_leave", "reporting", "retry_interval",
+	"retry_interval_wan", "retry_join", "retry_join_wan", "retry_max", "retry_max_wan", "rpc", "segment",
+	"segments", "serf_lan", "serf_lan_allowed_cidrs", "serf_wan", "serf_wan_allowed_cidrs", "server", "server_name",
+	"service", "services", "session_ttl_min", "skip_leave_on_interrupt", "start_join", "start_join_wan",
+	"syslog_facility", "telemetry", "tls", "translate_wan_addrs", "ui", "ui_config", "ui_dir", "unix_sockets",
+	"use_streaming_backend", "verify_incoming", "verify_incoming_https", "verify_incoming_rpc", "verify_outgoing",
+	"verify_server_hostname", "watches", "xds",
+)
+
+// VerifyEncoding parses contents, the rendered file, and checks that it decodes to values.
+func VerifyEncoding(file, contents string, values map[string]interface{}) error {
+	parsed, err := ParseConfig(contents)
+	if err != nil {
+		return fmt.Errorf("generated %s does not parse: %s", file, err)
+	}
+
+	if !reflect.DeepEqual(parsed, values) {
+		return fmt.Errorf("generated %s does not decode to the intended config", file)
+	}
+
+	return nil
+}
+
+// ValidateConfigDir parses every agent config file in path and checks their top-level keys against the
+// keys the Consul agent accepts.
+func ValidateConfigDir(path string) error {
+	var problems []string
+
+	for _, pattern := range []string{"*.hcl", "*.json"} {
+		files, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+
+			values, err := ParseConfig(string(content))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", filepath.Base(file), err))
+				continue
+			}
+
+			var unknown []string
+			for key := range values {
+				if !agentConfigKeys[key] {
+					unknown = append(unknown, key)
+				}
+			}
+
+			if len(unknown) > 0 {
+				sort.Strings(unknown)
+				problems = append(problems, fmt.Sprintf("%s: unknown keys %s", filepath.Base(file), strings.Join(unknown, ", ")))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// ValidateWithConsul runs `<binary> validate path`, which loads path the way the agent does.
+// consul validate fails without a data_dir, which agents such as the Docker image only get on the command line,
+// so a temporary data_dir file is added when no file in path sets one.
+func ValidateWithConsul(binary, path string) error {
+	args := []string{"validate", path}
+
+	if !setsKey(path, "data_dir") {
+		dataDir, err := ioutil.TempDir("", "consul-validate-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dataDir)
+
+		file := filepath.Join(dataDir, "data_dir.hcl")
+		if err := ioutil.WriteFile(file, []byte(EncodeHCL(map[string]interface{}{"data_dir": dataDir})), 0600); err != nil {
+			return err
+		}
+		args = append(args, file)
+	}
+
+	output, err := exec.Command(binary, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s validate failed (%s): %s", binary, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// setsKey reports whether an agent config file in path sets the top-level key.
+func setsKey(path, key string) bool {
+	for _, pattern := range []string{"*.hcl", "*.json"} {
+		files, _ := filepath.Glob(filepath.Join(path, pattern))
+
+		for _, file := range files {
+			if _, ok := readValues("", file)[key]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func toSet(keys ...string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+
+	return set
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeConsul writes a consul binary that records its arguments, and the files they name, to the returned file.
+func fakeConsul(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	binary, record := filepath.Join(dir, "consul"), filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + record + "\nfor arg in \"$@\"; do [ -f \"$arg\" ] && cat \"$arg\" >> " + record + "; done\nexit 0\n"
+
+	if err := ioutil.WriteFile(binary, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return binary, record
+}
+
+func TestValidateWithConsulDataDir(t *testing.T) {
+	cases := []struct {
+		name    string
+		files   map[string]string
+		dataDir bool
+	}{
+		{"data_dir on the command line", map[string]string{"agent.hcl": `server = true`}, true},
+		{"data_dir in hcl", map[string]string{"agent.hcl": `data_dir = "/consul/data"`}, false},
+		{"data_dir in json", map[string]string{"agent.json": `{"data_dir": "/consul/data"}`}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			binary, record := fakeConsul(t)
+			path := tempConfigDir(t, c.files)
+
+			if err := ValidateWithConsul(binary, path); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := ioutil.ReadFile(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args := strings.SplitN(string(content), "\n", 2)[0]
+			if !strings.HasPrefix(args, "validate "+path) {
+				t.Fatalf("consul was run with %q", args)
+			}
+
+			if added := strings.Contains(string(content), "data_dir = "); added != c.dataDir {
+				t.Fatalf("temporary data_dir added %t, want %t: %s", added, c.dataDir, content)
+			}
+		})
+	}
+}
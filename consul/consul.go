@@ -29,6 +29,8 @@ func (client *ConsulClient) QueryOpts() *consulApi.QueryOptions {
 
 /* Bootstrap Functions */
 
+// ConnectConsul only checks that the agent answers. Agents that have not joined a cluster yet have no leader,
+// use GetLeader where an elected leader is required.
 func ConnectConsul(config *consulApi.Config) (*consulApi.Client, error) {
 	log.Printf("==> Connecting to Consul Server running at %s", config.Address)
 	client, err := consulApi.NewClient(config)
@@ -66,6 +68,51 @@ func ConnectConsulWithRetry(config *consulApi.Config, retries, delay int) (*cons
 	}
 }
 
+// GetLeader returns an error while no leader is elected. Status().Leader() reports that as an empty address.
+func GetLeader(client *ConsulClient) (string, error) {
+	leader, err := client.Client.Status().Leader()
+	if err != nil {
+		return "", err
+	}
+
+	if leader == "" {
+		return "", errors.New("no cluster leader elected")
+	}
+
+	return leader, nil
+}
+
+func GetRaftServers(client *ConsulClient) ([]*consulApi.RaftServer, error) {
+	raft, err := client.Client.Operator().RaftGetConfiguration(client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return raft.Servers, nil
+}
+
+// ErrAclDisabled is returned by AclReady when the agent runs without ACLs, which waiting does not change.
+var ErrAclDisabled = errors.New("ACL support is disabled, set acl.enabled in the agent config")
+
+// AclReady returns an error while the ACL system is disabled or still in legacy mode.
+// Permission errors are expected for tokens without acl:read and mean the ACL system answered.
+func AclReady(client *ConsulClient) error {
+	_, _, err := client.Client.ACL().PolicyList(client.QueryOpts())
+	if err == nil || strings.Contains(err.Error(), "Permission denied") || strings.Contains(err.Error(), "ACL not found") {
+		return nil
+	}
+
+	if strings.Contains(err.Error(), "ACL support disabled") {
+		return ErrAclDisabled
+	}
+
+	if strings.Contains(err.Error(), "legacy mode") {
+		return errors.New("ACL system is in legacy mode")
+	}
+
+	return err
+}
+
 // BootstrapAcl Returns ACL Token, AlreadyBootstrapped, Error
 func BootstrapAcl(consulClient *consulApi.Client, retries, delay int) (*consulApi.ACLToken, bool, error) {
 	aclClient := consulClient.ACL()
@@ -161,6 +208,17 @@ func CreatePolicy(client *ConsulClient, name, description, rules string) (*consu
 	return policy, nil
 }
 
+func UpdatePolicy(client *ConsulClient, policy *consulApi.ACLPolicy) (*consulApi.ACLPolicy, error) {
+	aclClient := client.Client.ACL()
+
+	policy, _, err := aclClient.PolicyUpdate(policy, client.WriteOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
 func GroupAclPolicies(policies []*consulApi.ACLPolicy) []*consulApi.ACLTokenPolicyLink {
 	var aclPolicies []*consulApi.ACLTokenPolicyLink
 
@@ -247,6 +305,105 @@ func CreatePolicyToken(client *ConsulClient, description string, policy *consulA
 	return token, nil
 }
 
+// CreateExpiringToken creates a token with a caller chosen AccessorID that Consul deletes once ttl has passed.
+func CreateExpiringToken(client *ConsulClient, accessorId, description string, policy *consulApi.ACLPolicy, ttl time.Duration) (*consulApi.ACLToken, error) {
+	aclClient := client.Client.ACL()
+
+	aclToken := &consulApi.ACLToken{
+		AccessorID:    accessorId,
+		Description:   description,
+		Policies:      []*consulApi.ACLTokenPolicyLink{{Name: policy.Name}},
+		ExpirationTTL: ttl,
+	}
+
+	token, _, err := aclClient.TokenCreate(aclToken, client.WriteOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func GetSelfToken(client *ConsulClient) (*consulApi.ACLToken, error) {
+	aclClient := client.Client.ACL()
+
+	token, _, err := aclClient.TokenReadSelf(client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func DeleteToken(client *ConsulClient, accessorId string) error {
+	aclClient := client.Client.ACL()
+
+	_, err := aclClient.TokenDelete(accessorId, client.WriteOpts())
+	return err
+}
+
+// ListTokens returns every token of the client's datacenter.
+func ListTokens(client *ConsulClient) ([]*consulApi.ACLTokenListEntry, error) {
+	aclClient := client.Client.ACL()
+
+	tokens, _, err := aclClient.TokenList(client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// ImportToken creates token as given, keeping its AccessorID and SecretID.
+func ImportToken(client *ConsulClient, token *consulApi.ACLToken) (*consulApi.ACLToken, error) {
+	aclClient := client.Client.ACL()
+
+	token, _, err := aclClient.TokenCreate(token, client.WriteOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// ListTokensByPolicy returns the tokens linked to the named policy.
+func ListTokensByPolicy(client *ConsulClient, policyName string) ([]*consulApi.ACLTokenListEntry, error) {
+	aclClient := client.Client.ACL()
+
+	tokens, _, err := aclClient.TokenList(client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	var linked []*consulApi.ACLTokenListEntry
+	for _, token := range tokens {
+		for _, policy := range token.Policies {
+			if policy.Name == policyName {
+				linked = append(linked, token)
+				break
+			}
+		}
+	}
+
+	return linked, nil
+}
+
+func DeletePolicyByName(client *ConsulClient, policyName string) error {
+	policy, err := GetPolicyByName(client, policyName)
+	if err != nil {
+		return err
+	}
+
+	if policy == nil {
+		return nil
+	}
+
+	aclClient := client.Client.ACL()
+
+	_, err = aclClient.PolicyDelete(policy.ID, client.WriteOpts())
+	return err
+}
+
 func GetSecret(token *consulApi.ACLToken) string {
 	return token.SecretID
 }
@@ -264,6 +421,31 @@ func GetKV(client *ConsulClient, key string) (string, error) {
 	return string(pair.Value), nil
 }
 
+// GetKVPair returns nil without an error when the key does not exist.
+func GetKVPair(client *ConsulClient, key string) (*consulApi.KVPair, error) {
+	kvClient := client.Client.KV()
+
+	pair, _, err := kvClient.Get(key, client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// CreateKV only writes the key if it does not exist yet. Returns false if the key was already present.
+func CreateKV(client *ConsulClient, key string, value string) (bool, error) {
+	kvClient := client.Client.KV()
+
+	kvPair := &consulApi.KVPair{Key: key, Value: []byte(value), ModifyIndex: 0}
+	created, _, err := kvClient.CAS(kvPair, client.WriteOpts())
+	if err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
 func SaveKV(client *ConsulClient, key string, value string) error {
 	kvClient := client.Client.KV()
 
@@ -276,6 +458,83 @@ func SaveKV(client *ConsulClient, key string, value string) error {
 	return nil
 }
 
+// SaveKVStructCAS writes value only if the key's ModifyIndex is still index (0 when the key must not exist yet).
+func SaveKVStructCAS(client *ConsulClient, key string, value interface{}, index uint64) (bool, error) {
+	kvClient := client.Client.KV()
+
+	valueSerialized, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return false, err
+	}
+
+	kvPair := &consulApi.KVPair{Key: key, Value: valueSerialized, ModifyIndex: index}
+	saved, _, err := kvClient.CAS(kvPair, client.WriteOpts())
+	if err != nil {
+		return false, err
+	}
+
+	return saved, nil
+}
+
+func DeleteKV(client *ConsulClient, key string) error {
+	kvClient := client.Client.KV()
+
+	_, err := kvClient.Delete(key, client.WriteOpts())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteKVTree(client *ConsulClient, prefix string) error {
+	kvClient := client.Client.KV()
+
+	_, err := kvClient.DeleteTree(prefix, client.WriteOpts())
+	return err
+}
+
+func ListKV(client *ConsulClient, prefix string) (consulApi.KVPairs, error) {
+	kvClient := client.Client.KV()
+
+	pairs, _, err := kvClient.List(prefix, client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return pairs, nil
+}
+
+// ClaimKV atomically replaces record (if unchanged since it was read) and reads & deletes secretKey.
+// Returns false if another client claimed the record first.
+func ClaimKV(client *ConsulClient, record *consulApi.KVPair, value []byte, secretKey string) (string, bool, error) {
+	kvClient := client.Client.KV()
+
+	ops := consulApi.KVTxnOps{
+		&consulApi.KVTxnOp{Verb: consulApi.KVCheckIndex, Key: record.Key, Index: record.ModifyIndex},
+		&consulApi.KVTxnOp{Verb: consulApi.KVSet, Key: record.Key, Value: value},
+		&consulApi.KVTxnOp{Verb: consulApi.KVGet, Key: secretKey},
+		&consulApi.KVTxnOp{Verb: consulApi.KVDelete, Key: secretKey},
+	}
+
+	ok, response, _, err := kvClient.Txn(ops, client.QueryOpts())
+	if err != nil {
+		return "", false, err
+	}
+
+	if !ok {
+		return "", false, nil
+	}
+
+	for _, pair := range response.Results {
+		if pair != nil && pair.Key == secretKey {
+			return string(pair.Value), true, nil
+		}
+	}
+
+	return "", false, errors.New("claimed record has no secret")
+}
+
 func SaveKVStruct(client *ConsulClient, key string, value interface{}) error {
 	kvClient := client.Client.KV()
 
@@ -292,3 +551,252 @@ func SaveKVStruct(client *ConsulClient, key string, value interface{}) error {
 
 	return nil
 }
+
+// IsPermissionDenied reports whether err is an ACL permission error returned by the Consul API.
+func IsPermissionDenied(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Permission denied")
+}
+
+/* Lock Functions */
+
+// AcquireLock blocks until the session-backed lock on key is held, or fails once timeout has passed. value is stored
+// in the key to show who holds it.
+func AcquireLock(client *ConsulClient, key, value, sessionName string, timeout time.Duration) (*consulApi.Lock, error) {
+	lock, err := client.Client.LockOpts(&consulApi.LockOptions{
+		Key:         key,
+		Value:       []byte(value),
+		SessionName: sessionName,
+		SessionTTL:  "30s",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stopCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(stopCh) })
+	defer timer.Stop()
+
+	// Lock returns without an error when stopCh is closed before the lock is acquired.
+	leaderCh, err := lock.Lock(stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	if leaderCh == nil {
+		return nil, fmt.Errorf("timed out after %s waiting for lock %s", timeout, key)
+	}
+
+	return lock, nil
+}
+
+func ReleaseLock(lock *consulApi.Lock) error {
+	return lock.Unlock()
+}
+
+/* Keyring Functions */
+
+func ListKeyring(client *ConsulClient) ([]*consulApi.KeyringResponse, error) {
+	operatorClient := client.Client.Operator()
+
+	keyring, err := operatorClient.KeyringList(client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
+
+func InstallKeyringKey(client *ConsulClient, key string) error {
+	return client.Client.Operator().KeyringInstall(key, client.WriteOpts())
+}
+
+func UseKeyringKey(client *ConsulClient, key string) error {
+	return client.Client.Operator().KeyringUse(key, client.WriteOpts())
+}
+
+func RemoveKeyringKey(client *ConsulClient, key string) error {
+	return client.Client.Operator().KeyringRemove(key, client.WriteOpts())
+}
+
+/* Agent Functions */
+
+// GetAgentSelf queries /v1/agent/self with the client token, which the api Agent() helpers do not send.
+func GetAgentSelf(client *ConsulClient) (map[string]map[string]interface{}, error) {
+	var self map[string]map[string]interface{}
+
+	if _, err := client.Client.Raw().Query("/v1/agent/self", &self, client.QueryOpts()); err != nil {
+		return nil, err
+	}
+
+	return self, nil
+}
+
+func GetAgentDatacenter(client *ConsulClient) (string, error) {
+	self, err := GetAgentSelf(client)
+	if err != nil {
+		return "", err
+	}
+
+	datacenter, ok := self["Config"]["Datacenter"].(string)
+	if !ok || datacenter == "" {
+		return "", errors.New("agent did not report a datacenter")
+	}
+
+	return datacenter, nil
+}
+
+func GetAgentNodeName(client *ConsulClient) (string, error) {
+	self, err := GetAgentSelf(client)
+	if err != nil {
+		return "", err
+	}
+
+	nodeName, ok := self["Config"]["NodeName"].(string)
+	if !ok || nodeName == "" {
+		return "", errors.New("agent did not report a node name")
+	}
+
+	return nodeName, nil
+}
+
+// ReloadAgent triggers a configuration reload using the client token.
+func ReloadAgent(client *ConsulClient) error {
+	_, err := client.Client.Raw().Write("/v1/agent/reload", nil, nil, client.WriteOpts())
+	return err
+}
+
+// UpdateAgentToken sets the token the agent uses for its own operations, without a restart.
+func UpdateAgentToken(client *ConsulClient, token string) error {
+	_, err := client.Client.Agent().UpdateAgentACLToken(token, client.WriteOpts())
+	return err
+}
+
+// UpdateDefaultToken sets the token the agent uses for requests that carry no token, without a restart.
+func UpdateDefaultToken(client *ConsulClient, token string) error {
+	_, err := client.Client.Agent().UpdateDefaultACLToken(token, client.WriteOpts())
+	return err
+}
+
+func DeregisterAgentService(client *ConsulClient, serviceId string) error {
+	_, err := client.Client.Raw().Write("/v1/agent/service/deregister/"+serviceId, nil, nil, client.WriteOpts())
+	return err
+}
+
+/* Catalog Functions */
+
+// GetHealthyServices returns the instances of service carrying tag whose checks are all passing.
+func GetHealthyServices(client *ConsulClient, service, tag string) ([]*consulApi.ServiceEntry, error) {
+	healthClient := client.Client.Health()
+
+	entries, _, err := healthClient.Service(service, tag, true, client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// GetServiceInstance returns the catalog entry of serviceId, or nil if it is not registered.
+func GetServiceInstance(client *ConsulClient, service, serviceId string) (*consulApi.CatalogService, error) {
+	entries, _, err := client.Client.Catalog().Service(service, "", client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.ServiceID == serviceId {
+			return entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetServices returns every instance of service with its checks, healthy or not.
+func GetServices(client *ConsulClient, service string) ([]*consulApi.ServiceEntry, error) {
+	entries, _, err := client.Client.Health().Service(service, "", false, client.QueryOpts())
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func GetAgentVersion(client *ConsulClient) (string, error) {
+	self, err := GetAgentSelf(client)
+	if err != nil {
+		return "", err
+	}
+
+	version, ok := self["Config"]["Version"].(string)
+	if !ok || version == "" {
+		return "", errors.New("agent did not report a version")
+	}
+
+	return version, nil
+}
+
+func GetDatacenters(client *ConsulClient) ([]string, error) {
+	catalogClient := client.Client.Catalog()
+
+	datacenters, err := catalogClient.Datacenters()
+	if err != nil {
+		return nil, err
+	}
+
+	return datacenters, nil
+}
+
+// JoinWan asks the agent of the client to join address over the WAN gossip pool.
+// The request uses the token the underlying api client was created with.
+func JoinWan(client *ConsulClient, address string) error {
+	agentClient := client.Client.Agent()
+
+	return agentClient.Join(address, true)
+}
+
+/* Member Functions */
+
+func ListMembers(client *ConsulClient) ([]*consulApi.AgentMember, error) {
+	agentClient := client.Client.Agent()
+
+	members, err := agentClient.Members(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+/* Operator Functions */
+
+func GetAutopilotConfig(client *ConsulClient) (*consulApi.AutopilotConfiguration, error) {
+	return client.Client.Operator().AutopilotGetConfiguration(client.QueryOpts())
+}
+
+// SetAutopilotConfig uses a check-and-set against the ModifyIndex of config. Returns false if it changed in between.
+func SetAutopilotConfig(client *ConsulClient, config *consulApi.AutopilotConfiguration) (bool, error) {
+	return client.Client.Operator().AutopilotCASConfiguration(config, client.WriteOpts())
+}
+
+func GetAutopilotHealth(client *ConsulClient) (*consulApi.OperatorHealthReply, error) {
+	return client.Client.Operator().AutopilotServerHealth(client.QueryOpts())
+}
+
+// RemoveRaftPeer removes nodeName from the raft configuration. Returns false if it was not a raft peer.
+func RemoveRaftPeer(client *ConsulClient, nodeName string) (bool, error) {
+	operatorClient := client.Client.Operator()
+
+	raft, err := operatorClient.RaftGetConfiguration(client.QueryOpts())
+	if err != nil {
+		return false, err
+	}
+
+	for _, server := range raft.Servers {
+		if server.Node == nodeName {
+			return true, operatorClient.RaftRemovePeerByID(server.ID, client.WriteOpts())
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,144 @@
+package consul
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// NewExplicitConfig returns a client config for address that only uses settings set on it. Unlike consulApi.DefaultConfig()
+// it ignores the CONSUL_* environment variables, which describe the local cluster rather than the server at address.
+// Call BuildHttpClient once the TLS settings are complete.
+func NewExplicitConfig(address, token string) *consulApi.Config {
+	config := &consulApi.Config{
+		Address:   address,
+		Scheme:    "http",
+		Token:     token,
+		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+
+	if strings.HasPrefix(address, "https://") {
+		config.Scheme = "https"
+	}
+
+	return config
+}
+
+// BuildHttpClient sets up the HTTP client of config from its own TLS settings. consulApi.NewClient() fills empty
+// TLS settings from the environment before building the HTTP client, so it has to exist beforehand.
+func BuildHttpClient(config *consulApi.Config) error {
+	httpClient, err := consulApi.NewHttpClient(config.Transport, config.TLSConfig)
+	if err != nil {
+		return err
+	}
+
+	config.HttpClient = httpClient
+	return nil
+}
+
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// PinCAFingerprint only trusts servers whose certificate chain contains a CA with the given SHA-256 fingerprint.
+// When no CA file is configured, the pinned CA must be part of the chain presented by the server.
+func PinCAFingerprint(config *consulApi.Config, fingerprint string) error {
+	fingerprint = NormalizeFingerprint(fingerprint)
+	if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != sha256.Size*2 {
+		return errors.New("CA fingerprint must be a hex encoded SHA-256 hash")
+	}
+
+	tlsConfig, err := consulApi.SetupTLSConfig(&config.TLSConfig)
+	if err != nil {
+		return err
+	}
+
+	serverName := tlsConfig.ServerName
+	if serverName == "" {
+		serverName = hostFromAddress(config.Address)
+	}
+
+	// Without a configured CA the system roots cannot verify the server, so verification is done against the pinned CA instead.
+	verifyChain := config.TLSConfig.CAFile == "" && config.TLSConfig.CAPath == "" && len(config.TLSConfig.CAPem) == 0
+	if verifyChain {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if certFingerprint(cert) == fingerprint {
+					return nil
+				}
+			}
+		}
+
+		if !verifyChain {
+			return errors.New("server certificate is not signed by the pinned CA")
+		}
+
+		var presented []*x509.Certificate
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			presented = append(presented, cert)
+		}
+
+		if len(presented) == 0 {
+			return errors.New("server did not present a certificate")
+		}
+
+		roots := x509.NewCertPool()
+		intermediates := x509.NewCertPool()
+		for _, cert := range presented[1:] {
+			if certFingerprint(cert) == fingerprint {
+				roots.AddCert(cert)
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+
+		if _, err := presented[0].Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		}); err != nil {
+			return errors.New("server certificate is not signed by the pinned CA: " + err.Error())
+		}
+
+		return nil
+	}
+
+	if config.Transport == nil {
+		config.Transport = consulApi.DefaultConfig().Transport
+	}
+	config.Transport.TLSClientConfig = tlsConfig
+
+	return nil
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func hostFromAddress(address string) string {
+	if parsed, err := url.Parse(address); err == nil && parsed.Host != "" {
+		address = parsed.Host
+	}
+
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+
+	return address
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/certs"
+)
+
+// RunDaemon keeps the process alive and runs the periodic maintenance tasks every -daemon-interval.
+func RunDaemon(config *consulApi.Config, retries, delay int) {
+	log.Printf("==> Running in daemon mode. Checking every %s.", *daemonInterval)
+
+	clock := certs.SystemClock{}
+
+	for {
+		RunDaemonTasks(config, clock, retries, delay)
+		time.Sleep(*daemonInterval)
+	}
+}
+
+func RunDaemonTasks(config *consulApi.Config, clock certs.Clock, retries, delay int) {
+	if *enableTls {
+		PrintTlsStatus(clock)
+		RenewAgentTls(config, clock, retries, delay)
+	}
+
+	if (*registerNode || *renderJoin) && *zeroConfAddress != "" {
+		zeroConfClient := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
+		RenderJoinConfig(zeroConfClient)
+
+		if *registerNode {
+			bootstrap.TouchNodeRecord(zeroConfClient, *clusterId, *consulNodeName)
+			ApplyFederation(zeroConfClient)
+
+			// Clients using auto_config receive the gossip key from the servers.
+			if !*autoConfig || *nodeRole == bootstrap.ROLE_SERVER {
+				SyncGossipKey(zeroConfClient)
+			}
+
+			if *heartbeatTtl > 0 && !bootstrap.PassHeartbeat(zeroConfClient, *consulNodeName) {
+				log.Printf("==> %s has no heartbeat check on the ZeroConf Server. It may have been reaped, re-run -register-node.", *consulNodeName)
+			}
+		}
+	}
+
+	if *reap {
+		ReapDeadNodes(config, retries, delay)
+	}
+}
+
+func PrintStatus() {
+	log.Printf("==> Consul ZeroConf status for node %s", *consulNodeName)
+	PrintTlsStatus(certs.SystemClock{})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+type federatedCluster struct {
+	Id         string
+	Datacenter string
+	Servers    []*consulApi.ServiceEntry
+	Client     *consul.ConsulClient
+}
+
+// FederateClusters WAN joins the servers of the given clusters and stores the federation config each cluster's servers write on registration.
+func FederateClusters(config *consulApi.Config, retries, delay int) {
+	zeroConfClient := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		zeroConfClient.Token = *bootstrapToken
+	}
+
+	var clusters []*federatedCluster
+	var primary *federatedCluster
+	var datacenters, wanAddresses []string
+	seen := map[string]string{}
+
+	for _, id := range strings.Split(*federateClusters, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		servers := bootstrap.ClusterServerEntries(zeroConfClient, id)
+		if len(servers) == 0 {
+			log.Fatalf("==> Cluster %s has no healthy servers registered on the ZeroConf Server.", id)
+		}
+
+		datacenter := servers[0].Service.Meta["datacenter"]
+		for _, server := range servers {
+			if server.Service.Meta["datacenter"] != datacenter || datacenter == "" {
+				log.Fatalf("==> Servers of cluster %s do not agree on a datacenter.", id)
+			}
+		}
+
+		if other, exists := seen[datacenter]; exists {
+			log.Fatalf("==> Clusters %s and %s both use datacenter %s. Federated clusters need unique datacenters.", other, id, datacenter)
+		}
+		seen[datacenter] = id
+
+		cluster := &federatedCluster{
+			Id:         id,
+			Datacenter: datacenter,
+			Servers:    servers,
+			Client:     ConnectClusterServer(zeroConfClient, id, servers, bootstrap.FetchClusterManagementToken(zeroConfClient, id, *encryptionKey), retries, delay),
+		}
+
+		clusters = append(clusters, cluster)
+		datacenters = append(datacenters, datacenter)
+		wanAddresses = append(wanAddresses, bootstrap.WanAddresses(servers, *serfWanPort)...)
+
+		if (*primaryDatacenter == "" && primary == nil) || datacenter == *primaryDatacenter {
+			primary = cluster
+		}
+	}
+
+	if primary == nil {
+		log.Fatalf("==> None of the clusters uses the primary datacenter %s.", *primaryDatacenter)
+	}
+
+	log.Printf("==> Federating %d clusters with %s (%s) as the primary datacenter.", len(clusters), primary.Id, primary.Datacenter)
+
+	// ACL token replication replaces the global tokens of a secondary with those of the primary, including the
+	// tokens its nodes already use. Those are only kept if they are copied to the primary first.
+	for _, cluster := range clusters {
+		if cluster == primary {
+			continue
+		}
+
+		missing := bootstrap.MissingGlobalTokens(primary.Client, cluster.Client)
+		if len(missing) == 0 {
+			continue
+		}
+
+		if !*federateMigrateAcls {
+			log.Fatalf("==> %s: %d global ACL tokens are unknown to the primary datacenter and would be deleted by ACL token replication. Re-run with -federate-migrate-acls to copy them to the primary first.", cluster.Id, len(missing))
+		}
+
+		bootstrap.MigrateGlobalTokens(primary.Client, cluster.Client, missing)
+	}
+
+	replicationToken := bootstrap.SetupReplicationToken(primary.Client)
+	primaryWanAddresses := bootstrap.WanAddresses(primary.Servers, *serfWanPort)
+
+	for _, cluster := range clusters {
+		federation := &bootstrap.FederationConfig{
+			PrimaryDatacenter: primary.Datacenter,
+			WanAddresses:      wanAddresses,
+		}
+
+		if cluster != primary {
+			federation.ReplicationToken = replicationToken
+		}
+
+		bootstrap.SaveClusterFederation(zeroConfClient, cluster.Id, federation, *encryptionKey)
+
+		if cluster == primary {
+			continue
+		}
+
+		joined := false
+		for _, address := range primaryWanAddresses {
+			if err := consul.JoinWan(cluster.Client, address); err != nil {
+				log.Printf("==> %s: unable to WAN join %s (%s).", cluster.Id, address, err)
+				continue
+			}
+
+			log.Printf("==> %s: WAN joined %s.", cluster.Id, address)
+			joined = true
+			break
+		}
+
+		if !joined {
+			log.Printf("==> %s: could not WAN join the primary datacenter yet. Its servers will join through retry_join_wan.", cluster.Id)
+		}
+	}
+
+	federated := true
+	for _, cluster := range clusters {
+		if bootstrap.WaitForDatacenters(cluster.Client, datacenters, retries, delay) {
+			log.Printf("==> %s: sees all datacenters %v.", cluster.Id, datacenters)
+		} else {
+			federated = false
+		}
+	}
+
+	if !federated {
+		log.Fatal("==> Federation incomplete. Servers pick up the federation config on their next -register-node; re-run -federate to verify.")
+	}
+
+	log.Printf("==> Federation complete. Servers pick up the federation config on their next -register-node.")
+}
+
+// ConnectClusterServer connects to the first reachable server of a cluster registered on the ZeroConf server. Servers that
+// serve HTTPS are reached through it, trusting only the cluster CA.
+func ConnectClusterServer(zeroConfClient *consul.ConsulClient, clusterId string, servers []*consulApi.ServiceEntry, token string, retries, delay int) *consul.ConsulClient {
+	var tlsConfig *consulApi.TLSConfig
+	var lastErr error
+
+	for _, server := range servers {
+		address := "http://" + net.JoinHostPort(server.Service.Address, strconv.Itoa(server.Service.Port))
+
+		httpsPort := server.Service.Meta["https_port"]
+		if httpsPort != "" {
+			address = "https://" + net.JoinHostPort(server.Service.Address, httpsPort)
+
+			if tlsConfig == nil {
+				tlsConfig = bootstrap.ClusterClientTls(zeroConfClient, clusterId, server.Service.Meta["datacenter"], *encryptionKey)
+			}
+		} else {
+			log.Printf("==> %s: server %s does not serve HTTPS, so the management token is sent in plaintext. Register the cluster servers with -tls to avoid this.", clusterId, server.Service.ID)
+		}
+
+		config := consul.NewExplicitConfig(address, token)
+		if httpsPort != "" {
+			config.TLSConfig = *tlsConfig
+		}
+
+		if err := consul.BuildHttpClient(config); err != nil {
+			log.Fatal(err)
+		}
+
+		client, err := consul.ConnectConsulWithRetry(config, retries, delay)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return &consul.ConsulClient{
+			Client:     client,
+			Namespace:  config.Namespace,
+			Datacenter: config.Datacenter,
+			Token:      config.Token,
+		}
+	}
+
+	log.Fatal(lastErr)
+	return nil
+}
+
+// ApplyFederation writes the federation config on servers of a federated cluster.
+func ApplyFederation(zeroConfClient *consul.ConsulClient) {
+	if *nodeRole != bootstrap.ROLE_SERVER {
+		return
+	}
+
+	bootstrap.ApplyClusterFederation(zeroConfClient, *clusterId, *encryptionKey, *consulConfigDir, "federation")
+}
@@ -3,8 +3,10 @@ module redserenity.com/consul-bootstrap
 go 1.16
 
 require (
-	github.com/hashicorp/consul/api v1.8.1 // indirect
+	github.com/hashicorp/consul/api v1.8.1
+	github.com/hashicorp/hcl v1.0.0
 	github.com/integrii/flaggy v1.4.4 // indirect
 	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0 // indirect
 	github.com/sevlyar/go-daemon v0.1.5 // indirect
+	golang.org/x/crypto v0.0.0-20190923035154-9ee001bba392
 )
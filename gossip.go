@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+func RotateGossipKey(config *consulApi.Config, retries, delay int) {
+	consulClient := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		consulClient.Token = *bootstrapToken
+	}
+
+	zeroConfClient := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
+
+	rotation := bootstrap.StartGossipRotation(zeroConfClient, *clusterId, *encryptionKey)
+
+	if rotation.Stage == bootstrap.ROTATION_STAGE_INSTALL {
+		log.Printf("==> Installing new gossip key on all members.")
+		if err := consul.InstallKeyringKey(consulClient, rotation.NewKey); err != nil {
+			log.Fatal(err)
+		}
+
+		bootstrap.WaitForGossipKey(consulClient, rotation.NewKey, retries, delay)
+
+		rotation.Stage = bootstrap.ROTATION_STAGE_USE
+		bootstrap.SaveGossipRotation(zeroConfClient, *clusterId, rotation, *encryptionKey)
+	}
+
+	if rotation.Stage == bootstrap.ROTATION_STAGE_USE {
+		log.Printf("==> Switching primary gossip key.")
+		if err := consul.UseKeyringKey(consulClient, rotation.NewKey); err != nil {
+			log.Fatal(err)
+		}
+
+		bootstrap.SaveGossipKey(zeroConfClient, *clusterId, rotation.NewKey, *encryptionKey)
+		bootstrap.LockDownNodeJoining(rotation.NewKey, *consulConfigDir, "gossip")
+
+		rotation.Stage = bootstrap.ROTATION_STAGE_REMOVE
+		bootstrap.SaveGossipRotation(zeroConfClient, *clusterId, rotation, *encryptionKey)
+	}
+
+	if rotation.Stage == bootstrap.ROTATION_STAGE_REMOVE {
+		if bootstrap.GossipKeyInstalled(consulClient, rotation.OldKey) {
+			log.Printf("==> Removing old gossip key from all members.")
+			if err := consul.RemoveKeyringKey(consulClient, rotation.OldKey); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		bootstrap.FinishGossipRotation(zeroConfClient, *clusterId)
+	}
+
+	bootstrap.VerifyGossipKey(consulClient, rotation.NewKey)
+	bootstrap.RecordGossipKey(zeroConfClient, *clusterId, *consulNodeName, rotation.NewKey)
+
+	log.Printf("==> Gossip key rotation for cluster %s complete. Gossip config per node:", *clusterId)
+	if pending := bootstrap.ReportGossipKeyNodes(zeroConfClient, *clusterId, rotation.NewKey); len(pending) > 0 {
+		log.Printf("==> %d nodes still have the old key in their gossip config. Nodes running -register-node -daemon update it on their next run, others on their next -register-node. Re-run -rotate-gossip to check progress.", len(pending))
+	}
+}
+
+// SyncGossipKey writes the cluster gossip key stored on the ZeroConf Server into the gossip config and records
+// it in the node's inventory record, so a rotation run on another node reaches this one.
+func SyncGossipKey(zeroConfClient *consul.ConsulClient) string {
+	clusterGossipKey := bootstrap.FetchGossipKey(zeroConfClient, *clusterId, *encryptionKey)
+	bootstrap.LockDownNodeJoining(clusterGossipKey, *consulConfigDir, "gossip")
+	bootstrap.RecordGossipKey(zeroConfClient, *clusterId, *consulNodeName, clusterGossipKey)
+
+	return clusterGossipKey
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+)
+
+func ListNodes(config *consulApi.Config, retries, delay int) {
+	client := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		client.Token = *bootstrapToken
+	}
+
+	nodes := bootstrap.ListNodeRecords(client, "")
+
+	var err error
+	if *outputFormat == "json" {
+		err = bootstrap.WriteJson(os.Stdout, nodes)
+	} else {
+		err = bootstrap.WriteNodesTable(os.Stdout, nodes)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ShowNode(config *consulApi.Config, retries, delay int) {
+	client := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		client.Token = *bootstrapToken
+	}
+
+	node := bootstrap.GetNodeRecord(client, *clusterId, *consulNodeName)
+	if node == nil {
+		log.Fatalf("==> Node %s is not in the inventory of cluster %s.", *consulNodeName, *clusterId)
+	}
+
+	var err error
+	if *outputFormat == "json" {
+		err = bootstrap.WriteJson(os.Stdout, node)
+	} else {
+		err = bootstrap.WriteNodeDetails(os.Stdout, node)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+func RenderJoin(retries, delay int) {
+	zeroConfClient := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
+	RenderJoinConfig(zeroConfClient)
+}
+
+// RenderJoinConfig writes the join config with the healthy members of the cluster registered on the ZeroConf server.
+func RenderJoinConfig(zeroConfClient *consul.ConsulClient) bool {
+	addresses := bootstrap.DiscoverJoinAddresses(zeroConfClient, *clusterId, *consulNodeName)
+	return bootstrap.SaveJoinConfig(addresses, *consulConfigDir, "join")
+}
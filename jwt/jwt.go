@@ -0,0 +1,99 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+)
+
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  []string `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	Expiry    int64    `json:"exp"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+type SigningKey struct {
+	PrivateKeyPEM string
+	PublicKeyPEM  string
+}
+
+// GenerateSigningKey creates a P-256 key pair for ES256 signed tokens.
+func GenerateSigningKey() (*SigningKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	privateDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	publicDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateDer})),
+		PublicKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})),
+	}, nil
+}
+
+// Sign returns an ES256 signed JWT for claims.
+func Sign(privateKeyPEM string, claims *Claims) (string, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return "", errors.New("no EC private key found in PEM data")
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// ES256 signatures are the fixed size big-endian concatenation of r and s.
+	signature := make([]byte, 64)
+	fillBytes(r, signature[:32])
+	fillBytes(s, signature[32:])
+
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+func encodeSegment(segment []byte) string {
+	return base64.RawURLEncoding.EncodeToString(segment)
+}
+
+func fillBytes(value *big.Int, buf []byte) {
+	bytes := value.Bytes()
+	copy(buf[len(buf)-len(bytes):], bytes)
+}
@@ -4,9 +4,13 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/config"
 )
 
 var (
@@ -19,6 +23,14 @@ var (
 	consulNodeName   = flag.String("node-name", "", "Consul Node Name")
 	consulNodePrefix = flag.String("node-prefix", "Node-", "Policy prefix for node name")
 	consulConfigDir  = flag.String("config-dir", "/consul/config/", "Consul config directory")
+	configFormat     = flag.String("config-format", "hcl", "Format of written Consul config files (hcl or json)")
+	configMode       = flag.String("config-mode", "0600", "File mode of written config files that hold no certificates")
+	configOwner      = flag.String("config-owner", "", "User (name or uid) that owns written config files, e.g. consul")
+	configGroup      = flag.String("config-group", "", "Group (name or gid) that owns written config files")
+	caFile           = flag.String("ca-file", "", "CA certificate used to verify the Consul server")
+	clientCert       = flag.String("client-cert", "", "Client certificate used to authenticate with the Consul server")
+	clientKey        = flag.String("client-key", "", "Client key used to authenticate with the Consul server")
+	tlsServerName    = flag.String("tls-server-name", "", "Server name used to verify the Consul server certificate")
 
 	// ZeroConf Server
 	bootstrapServer = flag.Bool("bootstrap-server", false, "Bootstrap the ZeroConf Server")
@@ -28,12 +40,96 @@ var (
 	zeroConfAddress  = flag.String("zeroconf-address", "", "ZeroConf Server address")
 	zeroConfToken    = flag.String("zeroconf-token", "", "ZeroConf Server token used for Service Registration")
 
+	zeroConfTicket = flag.String("zeroconf-ticket", "", "Single-use join ticket redeemed for a ZeroConf Server token")
+	zeroConfFile   = flag.String("zeroconf-file", "", "ZeroConf client profile (zeroconf.json) used in place of -zeroconf-address, -zeroconf-token, -zeroconf-ca-fingerprint, -cluster-id and -datacenter")
+	exportZeroConf = flag.Bool("export-zeroconf", false, "Print the ZeroConf client profile for distribution to new nodes")
+
+	zeroConfCaFile        = flag.String("zeroconf-ca-file", "", "CA certificate used to verify the ZeroConf Server")
+	zeroConfClientCert    = flag.String("zeroconf-client-cert", "", "Client certificate used to authenticate with the ZeroConf Server")
+	zeroConfClientKey     = flag.String("zeroconf-client-key", "", "Client key used to authenticate with the ZeroConf Server")
+	zeroConfTlsServerName = flag.String("zeroconf-tls-server-name", "", "Server name used to verify the ZeroConf Server certificate")
+	zeroConfFingerprint   = flag.String("zeroconf-ca-fingerprint", "", "Only trust a ZeroConf Server signed by the CA with this SHA-256 fingerprint")
+
 	// ZeroConf Common
 	bootstrapToken = flag.String("bootstrap-token", "", "Consul Bootstrap Token")
 	zeroConfDir    = flag.String("zeroconf-dir", "/consul/zeroconf", "ZeroConf directory")
+	clusterId      = flag.String("cluster-id", "default", "ZeroConf Cluster ID used to group nodes and cluster secrets")
+	gossipKey      = flag.String("gossip-key", "", "Gossip encryption key for the cluster (generated if omitted)")
+	encryptionKey  = flag.String("encryption-key", "", "Passphrase used to encrypt secrets stored on the ZeroConf Server")
+
+	nodeRole    = flag.String("role", "", "Node role, server or client (defaults to server when bootstrapping and client when registering)")
+	serverCount = flag.Int("server-count", 0, "Number of servers in the cluster, used for bootstrap_expect (required for the first server)")
+
+	heartbeatTtl     = flag.Duration("heartbeat-ttl", 0, "Register a TTL heartbeat check the node keeps passing in daemon mode (disabled if 0)")
+	advertiseAddress = flag.String("advertise-address", "", "Address other cluster members reach this node on (detected if omitted)")
+	httpPort         = flag.Int("http-port", 8500, "Consul HTTP port of this node")
+	rpcPort          = flag.Int("rpc-port", 8300, "Consul server RPC port of this node")
+	serfPort         = flag.Int("serf-port", 8301, "Consul Serf LAN port of this node")
 
 	registerNode   = flag.Bool("register-node", false, "Register the node with the ZeroConf Server.")
+	force          = flag.Bool("force", false, "Register even if the node name is claimed by a different host on the ZeroConf Server")
 	deregisterNode = flag.Bool("deregister-node", false, "Deregister the node from the ZeroConf Server.")
+	decommission   = flag.Bool("decommission", false, "With -deregister-node, also remove the node from its cluster and delete its token, policy and KV records")
+	forceLeave     = flag.Bool("force-leave", false, "With -decommission, force-leave the node instead of leaving gracefully (use when the node is gone)")
+
+	// TLS
+	enableTls    = flag.Bool("tls", false, "Issue agent TLS certificates during bootstrap and registration")
+	createCa     = flag.Bool("create-ca", false, "Create the cluster certificate authority")
+	caDir        = flag.String("ca-dir", "", "Keep the certificate authority in this directory instead of on the ZeroConf Server")
+	tlsCertDays  = flag.Int("tls-cert-days", 365, "Validity of issued agent certificates in days")
+	tlsRenewDays = flag.Int("tls-renew-days", 30, "Renew agent certificates this many days before they expire (daemon mode)")
+	datacenter   = flag.String("datacenter", "", "Consul datacenter (detected from the agent if omitted)")
+
+	// Join Tickets
+	createTicket = flag.Bool("create-ticket", false, "Create a single-use join ticket for -node-name")
+	ticketTtl    = flag.Duration("ticket-ttl", 15*time.Minute, "Validity of join tickets")
+
+	// Auto Config
+	autoConfig        = flag.Bool("auto-config", false, "Use Consul auto_config to distribute ACL tokens, gossip key and certificates to client agents")
+	autoConfigServers = flag.String("auto-config-servers", "", "Comma separated server addresses client agents request their configuration from")
+	autoConfigTtl     = flag.Duration("auto-config-ttl", 24*time.Hour, "Validity of auto_config intro tokens")
+
+	// Daemon
+	daemon         = flag.Bool("daemon", false, "Keep running after the requested command and perform periodic maintenance")
+	daemonInterval = flag.Duration("daemon-interval", 5*time.Minute, "Interval between daemon maintenance runs")
+	status         = flag.Bool("status", false, "Display the status of files managed by ZeroConf")
+
+	// Inventory
+	listNodes    = flag.Bool("list-nodes", false, "List the nodes registered on the ZeroConf Server")
+	showNode     = flag.Bool("show-node", false, "Show the inventory record of -node-name")
+	outputFormat = flag.String("output", "table", "Output format of -list-nodes and -show-node, table or json")
+
+	// Federation
+	federate          = flag.Bool("federate", false, "WAN federate the clusters listed in -federate-clusters")
+	federateClusters  = flag.String("federate-clusters", "", "Comma separated cluster IDs to federate")
+	primaryDatacenter = flag.String("primary-datacenter", "", "Primary datacenter of the federation (defaults to the first cluster's datacenter)")
+	serfWanPort       = flag.Int("serf-wan-port", 8302, "Consul Serf WAN port of the cluster servers")
+
+	federateMigrateAcls = flag.Bool("federate-migrate-acls", false, "Copy global ACL tokens and policies of secondary datacenters to the primary before enabling ACL token replication")
+
+	reap             = flag.Bool("reap", false, "Deregister nodes whose heartbeat has been critical for longer than -reap-after (runs on the ZeroConf Server)")
+	reapAfter        = flag.Duration("reap-after", 30*time.Minute, "How long a heartbeat must be critical before the node is reaped")
+	reapDecommission = flag.Bool("reap-decommission", false, "Also delete the ACL token and policy of reaped nodes")
+
+	renderJoin = flag.Bool("render-join", false, "Write the join config with the cluster members registered on the ZeroConf Server.")
+
+	// Profiles
+	agentProfile = flag.String("profile", "", "Agent config profile written with the generated config: dev, edge, prod-client or prod-server")
+	profileFile  = flag.String("profile-file", "", "JSON file with profile overrides for every node (set) and for single nodes (nodes)")
+	profileSet   = flag.String("profile-set", "", "Comma separated profile overrides, e.g. log_level=TRACE,telemetry.statsd_address=127.0.0.1:8125")
+	render       = flag.Bool("render", false, "Print the config files of -profile without contacting Consul")
+
+	validateConfig = flag.Bool("validate", false, "Validate the whole -config-dir after writing it and before the agent is reloaded")
+	consulBinary   = flag.String("consul-binary", "consul", "Consul binary used by -validate (consul validate), the internal check is used when it is not found")
+
+	operatorConfig = flag.String("operator-config", "", "JSON file with autopilot and raft settings applied when bootstrapping a server")
+	checkOperator  = flag.Bool("check-operator", false, "Compare the autopilot configuration with -operator-config and report autopilot health")
+
+	rotateGossip = flag.Bool("rotate-gossip", false, "Rotate the cluster gossip key through the Operator Keyring API.")
+
+	waitCluster = flag.Bool("wait", false, "Wait until the -wait-for conditions are met")
+	waitFor     = flag.String("wait-for", "leader", "Comma separated readiness conditions: leader, servers=<count>, acl, node=<name> (registered on the ZeroConf Server)")
+	waitTimeout = flag.Duration("wait-timeout", 5*time.Minute, "Maximum time -wait waits for its conditions")
 
 	connectRetries = flag.Int("connect-retries", 10, "Number of times to retry connecting to Consul.")
 	connectDelay   = flag.Int("connect-delay", 5, "")
@@ -43,12 +139,22 @@ func init() {
 	flag.Parse()
 	HandleVersion()
 	HandleEnvVars()
+	HandleZeroConfFile()
 	ErrorCheckParams()
 }
 
 func main() {
 	consulConfig := consulApi.DefaultConfig()
 	consulConfig.Address = *consulAddress
+	ApplyTlsConfig(&consulConfig.TLSConfig, *caFile, *clientCert, *clientKey, *tlsServerName)
+
+	if *waitCluster {
+		WaitForCluster(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *zeroConfTicket != "" {
+		RedeemJoinTicket(*connectRetries, *connectDelay)
+	}
 
 	if *bootstrapServer {
 		client, bootstrapAclToken := BootstrapCommon(consulConfig, *connectRetries, *connectDelay)
@@ -59,11 +165,8 @@ func main() {
 	}
 
 	if *bootstrapCluster {
-		client, bootstrapAclToken := BootstrapCommon(consulConfig, *connectRetries, *connectDelay)
-		if bootstrapAclToken != nil {
-			BootstrapCluster(client, bootstrapAclToken, *connectRetries, *connectDelay)
-			log.Printf("==> ZeroConf Cluster bootstrap finished.")
-		}
+		BootstrapCluster(consulConfig, *connectRetries, *connectDelay)
+		log.Printf("==> ZeroConf Cluster bootstrap finished.")
 	}
 
 	if *registerNode {
@@ -73,6 +176,62 @@ func main() {
 	if *deregisterNode {
 		DeregisterZeroConfNode(consulConfig, *connectRetries, *connectDelay)
 	}
+
+	if *renderJoin {
+		RenderJoin(*connectRetries, *connectDelay)
+	}
+
+	if *render {
+		RenderProfile()
+	}
+
+	if *exportZeroConf {
+		ExportZeroConfProfile()
+	}
+
+	if *rotateGossip {
+		RotateGossipKey(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *checkOperator {
+		CheckOperatorSettings(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *createCa {
+		CreateCertificateAuthority(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *federate {
+		FederateClusters(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *listNodes {
+		ListNodes(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *showNode {
+		ShowNode(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *createTicket {
+		CreateJoinTicket(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *reap {
+		ReapDeadNodes(consulConfig, *connectRetries, *connectDelay)
+	}
+
+	if *status {
+		PrintStatus()
+	}
+
+	if *validateConfig {
+		ValidateConfigDir()
+	}
+
+	if *daemon {
+		RunDaemon(consulConfig, *connectRetries, *connectDelay)
+	}
 }
 
 func HandleVersion() {
@@ -87,9 +246,32 @@ func HandleEnvVars() {
 	envNodeName := os.Getenv("CONSUL_NODE_NAME")
 	envNodePrefix := os.Getenv("CONSUL_NODE_PREFIX")
 	envConfigDir := os.Getenv("CONSUL_CONFIG_DIR")
+	envCaFile := os.Getenv("CONSUL_CACERT")
+	envClientCert := os.Getenv("CONSUL_CLIENT_CERT")
+	envClientKey := os.Getenv("CONSUL_CLIENT_KEY")
+	envTlsServerName := os.Getenv("CONSUL_TLS_SERVER_NAME")
 
 	envZeroConfAddress := os.Getenv("CONSUL_ZEROCONF_ADDRESS")
 	envZeroConfToken := os.Getenv("CONSUL_ZEROCONF_TOKEN")
+	envAdvertiseAddress := os.Getenv("CONSUL_ZEROCONF_ADVERTISE_ADDRESS")
+	envZeroConfTicket := os.Getenv("CONSUL_ZEROCONF_TICKET")
+	envZeroConfFile := os.Getenv("CONSUL_ZEROCONF_FILE")
+	envZeroConfCaFile := os.Getenv("CONSUL_ZEROCONF_CACERT")
+	envZeroConfClientCert := os.Getenv("CONSUL_ZEROCONF_CLIENT_CERT")
+	envZeroConfClientKey := os.Getenv("CONSUL_ZEROCONF_CLIENT_KEY")
+	envZeroConfTlsServerName := os.Getenv("CONSUL_ZEROCONF_TLS_SERVER_NAME")
+	envZeroConfFingerprint := os.Getenv("CONSUL_ZEROCONF_CA_FINGERPRINT")
+	envClusterId := os.Getenv("CONSUL_ZEROCONF_CLUSTER_ID")
+	envGossipKey := os.Getenv("CONSUL_ZEROCONF_GOSSIP_KEY")
+	envEncryptionKey := os.Getenv("CONSUL_ZEROCONF_ENCRYPTION_KEY")
+	envDatacenter := os.Getenv("CONSUL_DATACENTER")
+	envOperatorConfig := os.Getenv("CONSUL_ZEROCONF_OPERATOR_CONFIG")
+	envConfigFormat := os.Getenv("CONSUL_ZEROCONF_CONFIG_FORMAT")
+	envProfile := os.Getenv("CONSUL_ZEROCONF_PROFILE")
+	envProfileFile := os.Getenv("CONSUL_ZEROCONF_PROFILE_FILE")
+	envConsulBinary := os.Getenv("CONSUL_ZEROCONF_CONSUL_BINARY")
+	envConfigOwner := os.Getenv("CONSUL_ZEROCONF_CONFIG_OWNER")
+	envConfigGroup := os.Getenv("CONSUL_ZEROCONF_CONFIG_GROUP")
 
 	if envConsulAddress != "" {
 		*consulAddress = envConsulAddress
@@ -107,6 +289,22 @@ func HandleEnvVars() {
 		*consulConfigDir = envConfigDir
 	}
 
+	if envCaFile != "" {
+		*caFile = envCaFile
+	}
+
+	if envClientCert != "" {
+		*clientCert = envClientCert
+	}
+
+	if envClientKey != "" {
+		*clientKey = envClientKey
+	}
+
+	if envTlsServerName != "" {
+		*tlsServerName = envTlsServerName
+	}
+
 	if envZeroConfAddress != "" {
 		*zeroConfAddress = envZeroConfAddress
 	}
@@ -114,6 +312,82 @@ func HandleEnvVars() {
 	if envZeroConfToken != "" {
 		*zeroConfToken = envZeroConfToken
 	}
+
+	if envAdvertiseAddress != "" {
+		*advertiseAddress = envAdvertiseAddress
+	}
+
+	if envZeroConfTicket != "" {
+		*zeroConfTicket = envZeroConfTicket
+	}
+
+	if envZeroConfFile != "" {
+		*zeroConfFile = envZeroConfFile
+	}
+
+	if envZeroConfCaFile != "" {
+		*zeroConfCaFile = envZeroConfCaFile
+	}
+
+	if envZeroConfClientCert != "" {
+		*zeroConfClientCert = envZeroConfClientCert
+	}
+
+	if envZeroConfClientKey != "" {
+		*zeroConfClientKey = envZeroConfClientKey
+	}
+
+	if envZeroConfTlsServerName != "" {
+		*zeroConfTlsServerName = envZeroConfTlsServerName
+	}
+
+	if envZeroConfFingerprint != "" {
+		*zeroConfFingerprint = envZeroConfFingerprint
+	}
+
+	if envClusterId != "" {
+		*clusterId = envClusterId
+	}
+
+	if envGossipKey != "" {
+		*gossipKey = envGossipKey
+	}
+
+	if envEncryptionKey != "" {
+		*encryptionKey = envEncryptionKey
+	}
+
+	if envDatacenter != "" {
+		*datacenter = envDatacenter
+	}
+
+	if envOperatorConfig != "" {
+		*operatorConfig = envOperatorConfig
+	}
+
+	if envConfigFormat != "" {
+		*configFormat = envConfigFormat
+	}
+
+	if envProfile != "" {
+		*agentProfile = envProfile
+	}
+
+	if envProfileFile != "" {
+		*profileFile = envProfileFile
+	}
+
+	if envConsulBinary != "" {
+		*consulBinary = envConsulBinary
+	}
+
+	if envConfigOwner != "" {
+		*configOwner = envConfigOwner
+	}
+
+	if envConfigGroup != "" {
+		*configGroup = envConfigGroup
+	}
 }
 
 func ErrorCheckParams() {
@@ -131,6 +405,47 @@ func ErrorCheckParams() {
 		*zeroConfDir = *zeroConfDir + "/"
 	}
 
+	// Nodes reach the ZeroConf Server on its own address unless -zeroconf-address says otherwise.
+	if *bootstrapServer && *zeroConfAddress == "" {
+		*zeroConfAddress = *consulAddress
+	}
+
+	if (*clientCert == "") != (*clientKey == "") {
+		log.Fatal("==> -client-cert and -client-key must be used together")
+	}
+
+	if (*zeroConfClientCert == "") != (*zeroConfClientKey == "") {
+		log.Fatal("==> -zeroconf-client-cert and -zeroconf-client-key must be used together")
+	}
+
+	if *zeroConfFingerprint != "" && !strings.HasPrefix(*zeroConfAddress, "https://") {
+		log.Fatal("==> -zeroconf-ca-fingerprint requires an https:// -zeroconf-address")
+	}
+
+	if *caDir != "" && !strings.HasSuffix(*caDir, "/") {
+		*caDir = *caDir + "/"
+	}
+
+	if *tlsCertDays < 1 {
+		log.Fatal("==> -tls-cert-days must be at least 1")
+	}
+
+	if *tlsRenewDays >= *tlsCertDays {
+		log.Fatal("==> -tls-renew-days must be lower than -tls-cert-days")
+	}
+
+	if *autoConfig && *bootstrapServer {
+		log.Fatal("==> -auto-config is for cluster nodes, the ZeroConf Server does not serve auto_config")
+	}
+
+	if *autoConfig && *registerNode && *autoConfigServers == "" {
+		log.Fatal("==> -auto-config-servers is required when using -auto-config with -register-node")
+	}
+
+	if *daemon && *daemonInterval < time.Second {
+		log.Fatal("==> -daemon-interval must be at least 1s")
+	}
+
 	if *bootstrapServer && *registerNode {
 		log.Fatal("==> Cannot specify both -bootstrap-server and -register-node")
 	}
@@ -143,16 +458,163 @@ func ErrorCheckParams() {
 		log.Fatal("==> Cannot specify both -bootstrap-server and -bootstrap-cluster")
 	}
 
-	if *bootstrapCluster && (*zeroConfAddress == "" || *zeroConfToken == "") {
-		log.Fatal("==> -zeroconf-address and -zeroconf-token are required when using -bootstrap-cluster. One or both are missing.")
+	if *bootstrapCluster && (*zeroConfAddress == "" || (*zeroConfToken == "" && *zeroConfTicket == "")) {
+		log.Fatal("==> -zeroconf-address and -zeroconf-token (or -zeroconf-ticket), or -zeroconf-file, are required when using -bootstrap-cluster. One or both are missing.")
+	}
+
+	if *registerNode && (*zeroConfAddress == "" || (*zeroConfToken == "" && *zeroConfTicket == "")) {
+		log.Fatal("==> -zeroconf-address and -zeroconf-token (or -zeroconf-ticket), or -zeroconf-file, are required when using -register-node. One or both are missing.")
+	}
+
+	if *zeroConfTicket != "" && *zeroConfAddress == "" {
+		log.Fatal("==> -zeroconf-address is required when using -zeroconf-ticket.")
+	}
+
+	if *decommission && !*deregisterNode {
+		log.Fatal("==> -decommission requires -deregister-node")
+	}
+
+	if *forceLeave && !*decommission {
+		log.Fatal("==> -force-leave requires -decommission")
+	}
+
+	if *waitCluster {
+		conditions, err := bootstrap.ParseWaitConditions(*waitFor)
+		if err != nil {
+			log.Fatalf("==> Invalid -wait-for: %s", err)
+		}
+
+		if _, ok := conditions["node"]; ok && *zeroConfAddress == "" {
+			log.Fatal("==> -zeroconf-address is required when waiting for node=<name>")
+		}
+	}
+
+	if *waitTimeout <= 0 {
+		log.Fatal("==> -wait-timeout must be greater than 0")
+	}
+
+	if *heartbeatTtl < 0 || (*heartbeatTtl > 0 && *heartbeatTtl < time.Second) {
+		log.Fatal("==> -heartbeat-ttl must be at least 1s")
+	}
+
+	if *daemon && *registerNode && *heartbeatTtl > 0 && *daemonInterval >= *heartbeatTtl {
+		log.Fatal("==> -daemon-interval must be lower than -heartbeat-ttl")
+	}
+
+	if *reap && *reapAfter <= 0 {
+		log.Fatal("==> -reap-after must be greater than 0")
+	}
+
+	if err := config.SetFormat(*configFormat); err != nil {
+		log.Fatalf("==> Invalid -config-format: %s", err)
+	}
+
+	mode, err := strconv.ParseUint(*configMode, 8, 32)
+	if err != nil || mode > 0777 {
+		log.Fatal("==> -config-mode must be an octal file mode such as 0600 or 0640")
+	}
+	config.SetDefaultMode(os.FileMode(mode))
+
+	if err := config.SetOwner(*configOwner, *configGroup); err != nil {
+		log.Fatalf("==> Invalid -config-owner or -config-group: %s", err)
+	}
+
+	if *federate && len(strings.Split(*federateClusters, ",")) < 2 {
+		log.Fatal("==> -federate-clusters must list at least two cluster IDs")
+	}
+
+	if *federate && *encryptionKey == "" {
+		log.Fatal("==> -encryption-key is required when using -federate. The ACL replication token is stored on the ZeroConf Server for the servers of each cluster and is only stored sealed.")
+	}
+
+	if *outputFormat != "table" && *outputFormat != "json" {
+		log.Fatal("==> -output must be either table or json")
+	}
+
+	if *showNode && *consulNodeName == "" {
+		log.Fatal("==> -node-name is required when using -show-node")
 	}
 
-	if *registerNode && (*zeroConfAddress == "" || *zeroConfToken == "") {
-		log.Fatal("==> -zeroconf-address and -zeroconf-token are required when using -register-node. One or both are missing.")
+	if *createTicket && *consulNodeName == "" {
+		log.Fatal("==> -node-name is required when using -create-ticket")
+	}
+
+	if *createTicket && *ticketTtl <= 0 {
+		log.Fatal("==> -ticket-ttl must be positive")
 	}
 
 	if *deregisterNode && (*zeroConfAddress == "" || *zeroConfToken == "") {
-		log.Fatal("==> -zeroconf-address and -zeroconf-token are required when using -deregister-node. One or both are missing.")
+		log.Fatal("==> -zeroconf-address and -zeroconf-token, or -zeroconf-file, are required when using -deregister-node. One or both are missing.")
+	}
+
+	if *exportZeroConf && (*zeroConfAddress == "" || *zeroConfToken == "") {
+		log.Fatal("==> -zeroconf-address and -zeroconf-token, or -zeroconf-file, are required when using -export-zeroconf. One or both are missing.")
+	}
+
+	if *renderJoin && (*zeroConfAddress == "" || *zeroConfToken == "") {
+		log.Fatal("==> -zeroconf-address and -zeroconf-token are required when using -render-join. One or both are missing.")
+	}
+
+	if *rotateGossip && (*zeroConfAddress == "" || *zeroConfToken == "") {
+		log.Fatal("==> -zeroconf-address and -zeroconf-token are required when using -rotate-gossip. One or both are missing.")
+	}
+
+	profileRole := ""
+	if *agentProfile != "" {
+		profile, err := bootstrap.NewProfile(*agentProfile)
+		if err != nil {
+			log.Fatalf("==> Invalid -profile: %s", err)
+		}
+		profileRole = profile.Role
+	} else if *render || *profileFile != "" || *profileSet != "" {
+		log.Fatal("==> -profile is required when using -render, -profile-file or -profile-set")
+	}
+
+	if *nodeRole == "" && profileRole != "" {
+		*nodeRole = profileRole
+	}
+
+	if *nodeRole == "" {
+		if *bootstrapCluster {
+			*nodeRole = bootstrap.ROLE_SERVER
+		} else {
+			*nodeRole = bootstrap.ROLE_CLIENT
+		}
+	}
+
+	if *nodeRole != bootstrap.ROLE_SERVER && *nodeRole != bootstrap.ROLE_CLIENT {
+		log.Fatal("==> -role must be either server or client")
+	}
+
+	if *bootstrapCluster && *nodeRole != bootstrap.ROLE_SERVER {
+		log.Fatal("==> -bootstrap-cluster must run on a server node")
+	}
+
+	// Consul refuses auto_config.authorization on servers without TLS certificates.
+	if *autoConfig && (*bootstrapCluster || (*registerNode && *nodeRole == bootstrap.ROLE_SERVER)) && !*enableTls {
+		log.Fatal("==> -auto-config requires -tls on server nodes")
+	}
+
+	if profileRole != "" && profileRole != *nodeRole {
+		log.Fatalf("==> -profile %s is meant for %s nodes, not %s nodes", *agentProfile, profileRole, *nodeRole)
+	}
+
+	if *serverCount < 0 {
+		log.Fatal("==> -server-count must not be negative")
+	}
+
+	if *clusterId == "" || strings.Contains(*clusterId, "/") {
+		log.Fatal("==> -cluster-id must not be empty or contain \"/\"")
+	}
+
+	if *clusterId == bootstrap.SERVER_CLUSTER_ID {
+		log.Fatalf("==> -cluster-id %s is reserved for the ZeroConf Server", bootstrap.SERVER_CLUSTER_ID)
+	}
+
+	if *gossipKey != "" {
+		if err := bootstrap.ValidateGossipKey(*gossipKey); err != nil {
+			log.Fatalf("==> Invalid -gossip-key: %s", err)
+		}
 	}
 
 	if *consulNodeName == "" {
@@ -166,4 +628,19 @@ func ErrorCheckParams() {
 	} else {
 		log.Printf("==> Node name set to %s", *consulNodeName)
 	}
-}
\ No newline at end of file
+
+	if err := bootstrap.ValidateNodeName(*consulNodeName); err != nil {
+		log.Fatalf("==> Invalid node name %q: %s", *consulNodeName, err)
+	}
+
+	if bootstrap.SanitizeNodeName(*consulNodeName) != *consulNodeName {
+		log.Printf("==> Node name %s is not DNS compatible and is encoded as %s in policy names and service IDs.", *consulNodeName, bootstrap.SanitizeNodeName(*consulNodeName))
+	}
+
+	if err := bootstrap.ValidatePolicyName(bootstrap.NodePolicyName(*consulNodePrefix, *consulNodeName)); err != nil {
+		log.Fatalf("==> Invalid -node-prefix: %s", err)
+	}
+
+	// Invalid profile overrides must fail before anything is written.
+	LoadAgentProfile()
+}
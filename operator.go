@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// ApplyOperatorSettings applies -operator-config to a freshly bootstrapped server.
+func ApplyOperatorSettings(client *consul.ConsulClient) {
+	if *operatorConfig == "" {
+		return
+	}
+
+	settings := bootstrap.LoadOperatorSettings(*operatorConfig)
+	bootstrap.ApplyAutopilotSettings(client, settings.Autopilot)
+	bootstrap.SaveRaftSettings(settings.Raft, *consulConfigDir, "operator")
+}
+
+// CheckOperatorSettings compares the cluster autopilot configuration with -operator-config and reports autopilot health.
+func CheckOperatorSettings(config *consulApi.Config, retries, delay int) {
+	client := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		client.Token = *bootstrapToken
+	}
+
+	current, err := consul.GetAutopilotConfig(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	settings := &bootstrap.OperatorSettings{}
+	if *operatorConfig != "" {
+		settings = bootstrap.LoadOperatorSettings(*operatorConfig)
+	}
+
+	matches := bootstrap.WriteAutopilotComparison(os.Stdout, current, bootstrap.DesiredAutopilotConfig(current, settings.Autopilot))
+
+	health, err := consul.GetAutopilotHealth(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bootstrap.WriteAutopilotHealth(os.Stdout, health)
+
+	if !matches || !health.Healthy {
+		os.Exit(1)
+	}
+}
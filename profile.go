@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/config"
+)
+
+// LoadAgentProfile returns -profile with this node's settings, the -profile-file overrides and -profile-set applied.
+// Returns nil when no profile is selected.
+func LoadAgentProfile() *bootstrap.Profile {
+	if *agentProfile == "" {
+		return nil
+	}
+
+	profile, err := bootstrap.NewProfile(*agentProfile)
+	if err != nil {
+		log.Fatalf("==> Invalid -profile: %s", err)
+	}
+
+	// The ports and address registered on the ZeroConf Server must be the ones the agent uses.
+	if profile.Ports == nil {
+		profile.Ports = &config.PortsConfig{}
+	}
+	profile.Ports.HTTP = *httpPort
+	profile.Ports.SerfLAN = *serfPort
+	if profile.Role == bootstrap.ROLE_SERVER {
+		profile.Ports.Server = *rpcPort
+	}
+
+	if *advertiseAddress != "" {
+		profile.AdvertiseAddr = *advertiseAddress
+
+		if profile.ClientAddr == bootstrap.PROFILE_CLIENT_ADDR {
+			profile.ClientAddr = "127.0.0.1 " + *advertiseAddress
+		}
+	}
+
+	if *datacenter != "" {
+		profile.Datacenter = *datacenter
+	}
+
+	if *profileFile != "" {
+		overrides := bootstrap.LoadProfileOverrides(*profileFile)
+		if err := bootstrap.ApplyProfileOverrides(profile, overrides, *consulNodeName); err != nil {
+			log.Fatalf("==> Invalid override in %s: %s", *profileFile, err)
+		}
+	}
+
+	if *profileSet != "" {
+		if err := bootstrap.ApplyProfileSet(profile, *profileSet); err != nil {
+			log.Fatalf("==> Invalid -profile-set: %s", err)
+		}
+	}
+
+	return profile
+}
+
+// RenderProfile writes the files of -profile to stdout without contacting Consul.
+func RenderProfile() {
+	if err := bootstrap.WriteProfile(os.Stdout, LoadAgentProfile(), *serverCount, *consulConfigDir); err != nil {
+		log.Fatal(err)
+	}
+}
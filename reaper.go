@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// ReapDeadNodes removes nodes whose heartbeat has been critical for longer than -reap-after.
+// Runs against the ZeroConf Server.
+func ReapDeadNodes(config *consulApi.Config, retries, delay int) {
+	client := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		client.Token = *bootstrapToken
+	}
+
+	dead := bootstrap.FindDeadNodes(client, *reapAfter)
+	if len(dead) == 0 {
+		log.Printf("==> No dead nodes to reap.")
+		return
+	}
+
+	for _, node := range dead {
+		reaped := &bootstrap.ReapedNode{
+			Node:          node.Name,
+			ClusterId:     node.ClusterId,
+			Address:       node.Address,
+			CriticalSince: *node.CriticalSince,
+			ReapedAt:      time.Now().UTC(),
+		}
+
+		if err := bootstrap.ReapNode(client, node); err != nil {
+			reaped.Errors = append(reaped.Errors, "deregister: "+err.Error())
+		}
+
+		if *reapDecommission {
+			if err := DecommissionReapedNode(client, node, retries, delay); err != nil {
+				reaped.Errors = append(reaped.Errors, "decommission: "+err.Error())
+			} else {
+				reaped.Decommissioned = true
+			}
+		}
+
+		bootstrap.SaveReapAudit(client, reaped)
+	}
+}
+
+// DecommissionReapedNode deletes the node's ACL token and policy in its cluster using the stored cluster bootstrap token,
+// and its registration policy on the ZeroConf server.
+func DecommissionReapedNode(zeroConfClient *consul.ConsulClient, node *bootstrap.ClusterNode, retries, delay int) error {
+	token := bootstrap.GetClusterBootstrapToken(zeroConfClient, node.ClusterId, *encryptionKey)
+	if token == "" {
+		return fmt.Errorf("no bootstrap token stored for cluster %s, it is only stored when -bootstrap-cluster runs with -encryption-key", node.ClusterId)
+	}
+
+	servers := bootstrap.ClusterServerEntries(zeroConfClient, node.ClusterId)
+	if len(servers) == 0 {
+		return fmt.Errorf("cluster %s has no healthy servers", node.ClusterId)
+	}
+
+	clusterClient := ConnectClusterServer(zeroConfClient, node.ClusterId, servers, token, retries, delay)
+
+	if _, err := bootstrap.RevokeNodeToken(clusterClient, node, node.Name, *consulNodePrefix); err != nil {
+		return err
+	}
+
+	_, err := bootstrap.RevokeNodeRegistration(zeroConfClient, node.Name)
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/config"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// ApplyAgentChanges applies the config written by this run to the running agent. New tokens are set through the
+// agent token API, the agent is reloaded and only the changes a reload cannot apply are reported as needing a restart.
+func ApplyAgentChanges(client *consul.ConsulClient) {
+	changes := config.TakeChanges()
+	if len(changes) == 0 {
+		log.Printf("==> Agent config is unchanged.")
+		return
+	}
+
+	if *validateConfig {
+		if err := CheckConfigDir(); err != nil {
+			log.Fatalf("==> Not reloading the agent, the config in %s is invalid: %s", *consulConfigDir, err)
+		}
+	}
+
+	bootstrap.PushAgentTokens(client, changes)
+
+	if err := consul.ReloadAgent(client); err != nil {
+		log.Printf("==> Unable to reload the agent (%s). Reload or restart it manually to apply the new config.", err)
+		return
+	}
+
+	if restart := bootstrap.RestartRequired(client, changes); len(restart) > 0 {
+		log.Printf("==> Agent reloaded. Restart it to apply %s, which cannot be changed on a running agent.", strings.Join(restart, ", "))
+		return
+	}
+
+	log.Printf("==> Agent reloaded with the new config. No restart required.")
+}
@@ -0,0 +1,172 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const ENCRYPTED_PREFIX = "enc:"
+
+const versionPrefix = ENCRYPTED_PREFIX + "v2:"
+
+const saltSize = 16
+
+// KDFParams are the scrypt parameters a value was sealed with. They are stored with the value,
+// so they can be raised later without breaking existing values.
+type KDFParams struct {
+	N, R, P int
+}
+
+// DefaultKDFParams are the scrypt parameters recommended for interactive logins.
+var DefaultKDFParams = KDFParams{N: 32768, R: 8, P: 1}
+
+// maxKDFParams bounds the parameters accepted from a stored value, so a tampered value cannot make
+// decryption use an unbounded amount of memory or time.
+var maxKDFParams = KDFParams{N: 1 << 20, R: 32, P: 16}
+
+// Encrypt seals plaintext with AES-256-GCM using a key derived from passphrase with scrypt.
+// The result is enc:v2:<N>:<r>:<p>:<base64 salt, nonce and ciphertext>.
+func Encrypt(passphrase, plaintext string) (string, error) {
+	return EncryptWithParams(passphrase, plaintext, DefaultKDFParams)
+}
+
+func EncryptWithParams(passphrase, plaintext string, params KDFParams) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("no encryption key was provided")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nil, nonce, []byte(plaintext), nil)
+
+	payload := append(salt, nonce...)
+	payload = append(payload, sealed...)
+
+	return fmt.Sprintf("%s%d:%d:%d:%s", versionPrefix, params.N, params.R, params.P, base64.StdEncoding.EncodeToString(payload)), nil
+}
+
+// Decrypt reverses Encrypt. Without a passphrase, values that are not encrypted are returned unchanged.
+// With a passphrase every value must be encrypted, so a value replaced with plaintext is not silently accepted.
+func Decrypt(passphrase, value string) (string, error) {
+	if !IsEncrypted(value) {
+		if passphrase != "" {
+			return "", errors.New("value is not encrypted but an encryption key was provided")
+		}
+		return value, nil
+	}
+
+	if passphrase == "" {
+		return "", errors.New("value is encrypted but no encryption key was provided")
+	}
+
+	if !strings.HasPrefix(value, versionPrefix) {
+		return "", errors.New("encrypted value uses an unknown format")
+	}
+
+	key, payload, err := parseValue(passphrase, strings.TrimPrefix(value, versionPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(payload) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	plaintext, err := gcm.Open(nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("unable to decrypt value, is the encryption key correct?")
+	}
+
+	return string(plaintext), nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ENCRYPTED_PREFIX)
+}
+
+// parseValue derives the key of a value and returns it with the nonce and ciphertext.
+func parseValue(passphrase, value string) ([]byte, []byte, error) {
+	fields := strings.SplitN(value, ":", 4)
+	if len(fields) != 4 {
+		return nil, nil, errors.New("encrypted value is missing its key derivation parameters")
+	}
+
+	var params [3]int
+	for i := range params {
+		param, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return nil, nil, errors.New("encrypted value has invalid key derivation parameters")
+		}
+		params[i] = param
+	}
+
+	if params[0] > maxKDFParams.N || params[1] > maxKDFParams.R || params[2] > maxKDFParams.P {
+		return nil, nil, errors.New("encrypted value has key derivation parameters above the supported maximum")
+	}
+
+	payload, salt, err := splitSalt(fields[3])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, params[0], params[1], params[2], 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypted value has invalid key derivation parameters: %s", err)
+	}
+
+	return key, payload, nil
+}
+
+func splitSalt(value string) ([]byte, []byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(payload) < saltSize {
+		return nil, nil, errors.New("encrypted value is too short")
+	}
+
+	return payload[saltSize:], payload[:saltSize:saltSize], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
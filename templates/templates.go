@@ -28,7 +28,10 @@ key_prefix "_rexec" {
 const REGISTRATION_POLICY = `service "consul-cluster" {
 	policy = "write"
 }
-key_prefix "cluster/nodes" {
+key_prefix "clusters/{{.ClusterId}}/" {
+  policy = "write"
+}
+session "{{.ServerNode}}" {
   policy = "write"
 }
 service_prefix "" {
@@ -39,12 +42,15 @@ node_prefix "" {
 }
 `
 
-const ACL_CONFIG = `acl {
-  enabled                  = true
-  default_policy           = "deny"
-  enable_token_persistence = true
+const TICKET_POLICY = `key_prefix "tickets/{{.ID}}" {
+  policy = "write"
+}
+`
 
-  tokens {
-    agent = "{{.SecretID}}"
-  }
-}`
+const REPLICATION_POLICY = `acl = "write"
+operator = "write"
+service_prefix "" {
+  policy     = "read"
+  intentions = "read"
+}
+`
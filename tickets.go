@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+)
+
+func CreateJoinTicket(config *consulApi.Config, retries, delay int) {
+	client := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		client.Token = *bootstrapToken
+	}
+
+	bootstrap.SweepTickets(client)
+
+	ticket, secret := bootstrap.CreateTicket(client, *consulNodeName, *clusterId, *ticketTtl)
+	log.Printf("==> (Sensitive) Join ticket for %s = %s", ticket.Node, secret)
+	log.Printf("==> Ticket expires at %s and can be redeemed once with -zeroconf-ticket.", ticket.ExpiresAt.Format(time.RFC3339))
+}
+
+// RedeemJoinTicket swaps -zeroconf-ticket for the node's registration token and keeps it in the client profile
+// in -zeroconf-dir, so later runs can use it with -zeroconf-file. When the profile already holds the token of
+// this node, e.g. as the container restarted with the same ticket, the kept token is used instead.
+func RedeemJoinTicket(retries, delay int) {
+	if token := bootstrap.RedeemedTicketToken(*zeroConfDir, "zeroconf.json", *consulNodeName); token != "" {
+		log.Printf("==> Join ticket for %s was already redeemed. Using the token kept in %szeroconf.json.", *consulNodeName, *zeroConfDir)
+		*zeroConfToken = token
+		return
+	}
+
+	ticketClient := ConnectZeroConfServer(*zeroConfAddress, *zeroConfTicket, retries, delay)
+	*zeroConfToken = bootstrap.RedeemTicket(ticketClient, *consulNodeName)
+
+	bootstrap.SaveZeroConfProfile(&bootstrap.ZeroConf{
+		Address:       *zeroConfAddress,
+		Token:         *zeroConfToken,
+		CaFingerprint: *zeroConfFingerprint,
+		ClusterId:     *clusterId,
+		Datacenter:    *datacenter,
+		Node:          *consulNodeName,
+	}, *zeroConfDir, "zeroconf.json")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/certs"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+func CreateCertificateAuthority(config *consulApi.Config, retries, delay int) {
+	if *caDir != "" {
+		bootstrap.LoadLocalCA(*caDir, *encryptionKey)
+		log.Printf("==> Certificate authority available in %s.", *caDir)
+		return
+	}
+
+	client := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		client.Token = *bootstrapToken
+	}
+
+	if bootstrap.CreateClusterCA(client, *clusterId, *encryptionKey) == nil {
+		log.Printf("==> Cluster %s already has a certificate authority. Nothing to do.", *clusterId)
+		return
+	}
+
+	log.Printf("==> Certificate authority for cluster %s created.", *clusterId)
+}
+
+// SetupAgentTls issues a certificate for this node and writes the tls config. caClient is the client holding the cluster CA.
+func SetupAgentTls(caClient, localClient *consul.ConsulClient, server bool) {
+	clock := certs.SystemClock{}
+	ca := LoadCertificateAuthority(caClient)
+	bootstrap.CAOutlives(ca, clock, RenewThreshold())
+
+	cert := bootstrap.IssueAgentCert(ca, *consulNodeName, ResolveDatacenter(localClient), server, *tlsCertDays, clock)
+	bootstrap.SaveAgentTls(ca, cert, server, *consulConfigDir, "tls")
+}
+
+func LoadCertificateAuthority(client *consul.ConsulClient) *certs.Certificate {
+	if *caDir != "" {
+		return bootstrap.LoadLocalCA(*caDir, *encryptionKey)
+	}
+
+	return bootstrap.FetchClusterCA(client, AgentClusterId(), *encryptionKey)
+}
+
+// AgentClusterId is the cluster whose secrets the local agent uses. The ZeroConf Server has its own,
+// so a managed cluster never shares its gossip key or CA.
+func AgentClusterId() string {
+	if *bootstrapServer {
+		return bootstrap.SERVER_CLUSTER_ID
+	}
+
+	return *clusterId
+}
+
+func ResolveDatacenter(client *consul.ConsulClient) string {
+	if *datacenter != "" {
+		return *datacenter
+	}
+
+	agentDatacenter, err := consul.GetAgentDatacenter(client)
+	if err != nil {
+		log.Fatalf("==> Unable to detect the agent datacenter (%s). Use -datacenter to set it.", err)
+	}
+
+	return agentDatacenter
+}
+
+// RenewAgentTls re-issues the agent certificate once it is within -tls-renew-days of expiring and reloads the agent.
+func RenewAgentTls(config *consulApi.Config, clock certs.Clock, retries, delay int) {
+	cert := bootstrap.LoadAgentCert(*consulConfigDir, bootstrap.AGENT_FILE)
+	if cert == nil {
+		log.Printf("==> No agent certificate found in %s. Skipping renewal.", *consulConfigDir)
+		return
+	}
+
+	threshold := RenewThreshold()
+	if !certs.NeedsRenewal(cert, clock, threshold) {
+		return
+	}
+
+	log.Printf("==> Agent certificate expires in %d days. Renewing.", certs.DaysUntilExpiry(cert, clock))
+
+	consulClient := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		consulClient.Token = *bootstrapToken
+	}
+
+	// The ZeroConf Server holds the CA itself, -zeroconf-address is only the address it hands out to nodes.
+	caClient := consulClient
+	if *zeroConfAddress != "" && !*bootstrapServer {
+		caClient = ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
+	}
+
+	ca := LoadCertificateAuthority(caClient)
+	if !bootstrap.CAOutlives(ca, clock, threshold) {
+		// The renewed certificate would be due for renewal again on the next run.
+		log.Printf("==> Not renewing the agent certificate.")
+		return
+	}
+
+	server := certs.IsServerCert(cert)
+	renewed := bootstrap.IssueAgentCert(ca, *consulNodeName, ResolveDatacenter(consulClient), server, *tlsCertDays, clock)
+	bootstrap.SaveAgentTls(ca, renewed, server, *consulConfigDir, "tls")
+
+	if *validateConfig {
+		if err := CheckConfigDir(); err != nil {
+			log.Printf("==> Not reloading the agent, the config in %s is invalid: %s", *consulConfigDir, err)
+			return
+		}
+	}
+
+	if err := consul.ReloadAgent(consulClient); err != nil {
+		log.Printf("==> Unable to reload the agent (%s). Reload or restart it manually to use the new certificate.", err)
+		return
+	}
+
+	log.Printf("==> Agent reloaded with the renewed certificate.")
+}
+
+func RenewThreshold() time.Duration {
+	return time.Duration(*tlsRenewDays) * 24 * time.Hour
+}
+
+func PrintTlsStatus(clock certs.Clock) {
+	for _, file := range []string{bootstrap.CA_FILE, bootstrap.AGENT_FILE} {
+		cert := bootstrap.LoadAgentCert(*consulConfigDir, file)
+		if cert == nil {
+			log.Printf("==> %s: not present", file)
+			continue
+		}
+
+		log.Printf("==> %s: %s, expires %s (%d days)", file, cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339), certs.DaysUntilExpiry(cert, clock))
+
+		if file == bootstrap.CA_FILE && certs.NeedsRenewal(cert, clock, RenewThreshold()) {
+			log.Printf("==> %s: the CA is within -tls-renew-days of expiring. Agent certificates cannot be renewed past it.", file)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"redserenity.com/consul-bootstrap/config"
+)
+
+// CheckConfigDir validates the whole -config-dir with `consul validate` when -consul-binary is found,
+// and with the internal parse and key check otherwise. When the check fails, the files written since the
+// last check are restored, so neither a reload nor a restart of the agent picks up the broken config.
+func CheckConfigDir() error {
+	err := checkConfigDir()
+	if err == nil {
+		config.TakeWrites()
+		return nil
+	}
+
+	restored, restoreErr := config.RestoreWrites()
+	if restoreErr != nil {
+		return fmt.Errorf("%s. Restoring the previous config failed as well: %s", err, restoreErr)
+	}
+
+	if len(restored) > 0 {
+		return fmt.Errorf("%s. Restored the previous version of %s", err, strings.Join(restored, ", "))
+	}
+
+	return err
+}
+
+func checkConfigDir() error {
+	binary, err := exec.LookPath(*consulBinary)
+	if err != nil {
+		log.Printf("==> %s not found. Validating %s with the internal check.", *consulBinary, *consulConfigDir)
+		return config.ValidateConfigDir(*consulConfigDir)
+	}
+
+	log.Printf("==> Validating %s with %s validate.", *consulConfigDir, binary)
+	return config.ValidateWithConsul(binary, *consulConfigDir)
+}
+
+// ValidateConfigDir stops with an error when -config-dir would not load, so the agent is not restarted with it.
+func ValidateConfigDir() {
+	if err := CheckConfigDir(); err != nil {
+		log.Fatalf("==> Invalid config in %s: %s", *consulConfigDir, err)
+	}
+
+	log.Printf("==> Config in %s is valid.", *consulConfigDir)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// WaitForCluster blocks until every -wait-for condition is met or -wait-timeout passes.
+func WaitForCluster(config *consulApi.Config, retries, delay int) {
+	conditions, err := bootstrap.ParseWaitConditions(*waitFor)
+	if err != nil {
+		log.Fatalf("==> Invalid -wait-for: %s", err)
+	}
+
+	client := ConnectConsulServer(config, retries, delay)
+	if *bootstrapToken != "" {
+		client.Token = *bootstrapToken
+	}
+
+	var checks []*bootstrap.WaitCondition
+	if _, ok := conditions["leader"]; ok {
+		checks = append(checks, bootstrap.LeaderCondition(client))
+	}
+	if value, ok := conditions["servers"]; ok {
+		count, _ := strconv.Atoi(value)
+		checks = append(checks, bootstrap.RaftServersCondition(client, count))
+	}
+	if _, ok := conditions["acl"]; ok {
+		checks = append(checks, bootstrap.AclCondition(client))
+	}
+	if nodeName, ok := conditions["node"]; ok {
+		zeroConfClient := ConnectZeroConfServer(*zeroConfAddress, *zeroConfToken, retries, delay)
+		checks = append(checks, bootstrap.NodeRegisteredCondition(zeroConfClient, nodeName))
+	}
+
+	if err := bootstrap.WaitFor(checks, *waitTimeout, time.Duration(delay)*time.Second); err != nil {
+		log.Fatalf("==> %s", err)
+	}
+
+	log.Printf("==> Cluster is ready.")
+}
+
+// WaitForReady gates a bootstrap step on conditions, allowing as long as the connect retries would.
+func WaitForReady(retries, delay int, conditions ...*bootstrap.WaitCondition) {
+	timeout := time.Duration(retries*delay) * time.Second
+	if err := bootstrap.WaitFor(conditions, timeout, time.Duration(delay)*time.Second); err != nil {
+		log.Fatalf("==> %s", err)
+	}
+}
+
+// WaitForLeader is used for agents that must be part of a working cluster before the next step.
+func WaitForLeader(client *consul.ConsulClient, retries, delay int) {
+	WaitForReady(retries, delay, bootstrap.LeaderCondition(client))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"redserenity.com/consul-bootstrap/bootstrap"
+	"redserenity.com/consul-bootstrap/certs"
+	"redserenity.com/consul-bootstrap/consul"
+)
+
+// HandleZeroConfFile fills the ZeroConf Server settings from -zeroconf-file. Settings given as flags
+// or environment variables take precedence.
+func HandleZeroConfFile() {
+	if *zeroConfFile == "" {
+		return
+	}
+
+	profile := bootstrap.LoadZeroConfProfile(*zeroConfFile)
+
+	for _, setting := range []struct {
+		value        *string
+		profileValue string
+	}{
+		{zeroConfAddress, profile.Address},
+		{zeroConfToken, profile.Token},
+		{zeroConfFingerprint, profile.CaFingerprint},
+		{datacenter, profile.Datacenter},
+	} {
+		if *setting.value == "" {
+			*setting.value = setting.profileValue
+		}
+	}
+
+	// -cluster-id has a default, so only an explicit value takes precedence.
+	clusterIdSet := os.Getenv("CONSUL_ZEROCONF_CLUSTER_ID") != ""
+	flag.Visit(func(f *flag.Flag) {
+		clusterIdSet = clusterIdSet || f.Name == "cluster-id"
+	})
+	if profile.ClusterId != "" && !clusterIdSet {
+		*clusterId = profile.ClusterId
+	}
+}
+
+// ServerZeroConfProfile describes how nodes reach the ZeroConf Server bootstrapped with client. The datacenter of
+// the ZeroConf Server is left out, as the clusters it manages have their own.
+func ServerZeroConfProfile(client *consul.ConsulClient, token string) *bootstrap.ZeroConf {
+	fingerprint := *zeroConfFingerprint
+	if fingerprint == "" && *enableTls && strings.HasPrefix(*zeroConfAddress, "https://") {
+		caFingerprint, err := certs.Fingerprint(LoadCertificateAuthority(client).CertPEM)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fingerprint = caFingerprint
+	}
+
+	return &bootstrap.ZeroConf{
+		Address:       *zeroConfAddress,
+		Token:         token,
+		CaFingerprint: fingerprint,
+		ClusterId:     *clusterId,
+	}
+}
+
+// ExportZeroConfProfile prints the client profile new nodes use with -zeroconf-file. It is built from -zeroconf-file
+// and the ZeroConf Server flags. The registration token only registers nodes of the cluster it was created for, so a
+// profile for another -cluster-id also needs that cluster's -zeroconf-token.
+func ExportZeroConfProfile() {
+	err := bootstrap.WriteZeroConfProfile(os.Stdout, &bootstrap.ZeroConf{
+		Address:       *zeroConfAddress,
+		Token:         *zeroConfToken,
+		CaFingerprint: *zeroConfFingerprint,
+		ClusterId:     *clusterId,
+		Datacenter:    *datacenter,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}